@@ -0,0 +1,62 @@
+package buff
+
+// Chain runs hs as an indexed chain: each handler may call c.Next to run the
+// rest of the chain before doing its own post-processing, or c.Abort to stop
+// it. A handler that returns without calling Next is followed by the next
+// one unless it aborted.
+//
+//	e.GET("/admin", buff.Chain(auth, audit, handler))
+func Chain(hs ...Handler) Handler {
+	return func(c *Context) {
+		outer, i := c.handlers, c.index
+		c.handlers, c.index = hs, -1
+		c.Next()
+		c.handlers, c.index = outer, i
+	}
+}
+
+// AsMiddleware turns a Next-style handler into a Middleware, so it can be
+// passed to Use or to a route alongside wrapping middleware.
+func AsMiddleware(h Handler) Middleware {
+	return func(next Handler) Handler { return Chain(h, next) }
+}
+
+// AsHandler turns a Middleware into a step of a Chain. A middleware that does
+// not call its next handler aborts the chain.
+func AsHandler(m Middleware) Handler {
+	h := m(func(c *Context) { c.Next() })
+	return func(c *Context) {
+		i := c.index
+		h(c)
+		if c.index == i {
+			c.Abort()
+		}
+	}
+}
+
+// Next runs the remaining handlers of the current chain. Outside a Chain it
+// does nothing.
+func (c *Context) Next() {
+	for c.index++; c.index < len(c.handlers) && !c.aborted; c.index++ {
+		c.handlers[c.index](c)
+	}
+}
+
+// Abort stops the handlers after the current one. Handlers already running
+// finish, and post-processing code after their Next call can check
+// IsAborted. The abort is visible to every enclosing chain.
+func (c *Context) Abort() { c.aborted = true }
+
+func (c *Context) IsAborted() bool { return c.aborted }
+
+// AbortWithStatus aborts and writes the status code without a body.
+func (c *Context) AbortWithStatus(code int) {
+	c.Abort()
+	c.Writer.WriteHeader(code)
+}
+
+// AbortWithStatusJSON aborts and responds with v as JSON.
+func (c *Context) AbortWithStatusJSON(code int, v any) error {
+	c.Abort()
+	return c.JSON(code, v)
+}
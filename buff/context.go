@@ -13,6 +13,16 @@ type Context struct {
 	pbuf    [8]paramKV
 	sw      statusWriter
 	store   map[string]any
+	router  *Router
+
+	// indexed chain run by Chain; see Next and Abort.
+	handlers []Handler
+	index    int
+	aborted  bool
+
+	// errs[:rendered] have already gone to the error handler.
+	errs     []error
+	rendered int
 
 	Route string
 }
@@ -57,7 +67,14 @@ func (c *Context) JSON(code int, v any) error {
 	return enc.Encode(v)
 }
 
-func (c *Context) Bind(v any) error { return json.NewDecoder(c.Request.Body).Decode(v) }
+// Bind decodes the JSON request body into v. Decoding failures are returned
+// as *BindError.
+func (c *Context) Bind(v any) error {
+	if err := json.NewDecoder(c.Request.Body).Decode(v); err != nil {
+		return &BindError{Err: err}
+	}
+	return nil
+}
 func (c *Context) Redirect(code int, url string) error {
 	http.Redirect(c.Writer, c.Request, url, code)
 	return nil
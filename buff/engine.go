@@ -11,37 +11,126 @@ import (
 )
 
 type Engine struct {
-	R          *Router
-	mws        []Middleware
-	httpServer *http.Server
+	R            *Router
+	mws          []Middleware
+	httpServer   *http.Server
+	maxBodyBytes int64
 }
 
 func NewEngine() *Engine { return &Engine{R: NewRouter()} }
 
-func (e *Engine) Use(mw ...Middleware) { e.mws = append(e.mws, mw...) }
+func (e *Engine) Use(mw ...Middleware) {
+	e.mws = append(e.mws, mw...)
+	e.R.setEngineMiddleware(e.mws)
+}
+
+// NotFound sets the handler for unmatched paths; see Router.NotFound.
+func (e *Engine) NotFound(h Handler) { e.R.NotFound(h) }
+
+// MethodNotAllowed sets the 405 handler; see Router.MethodNotAllowed.
+func (e *Engine) MethodNotAllowed(h Handler) { e.R.MethodNotAllowed(h) }
+
+// ErrorHandler sets how recorded errors are rendered; see Router.ErrorHandler.
+func (e *Engine) ErrorHandler(h ErrorHandler) { e.R.ErrorHandler(h) }
+
+// URL builds the path of a named route; see Router.URL.
+func (e *Engine) URL(name string, pairs ...string) (string, error) { return e.R.URL(name, pairs...) }
+
+// SetProblemDetails renders framework errors as application/problem+json;
+// see Router.SetProblemDetails.
+func (e *Engine) SetProblemDetails(on bool) { e.R.SetProblemDetails(on) }
+
+// SetPathPolicy sets the router's handling of non-canonical paths.
+func (e *Engine) SetPathPolicy(p PathPolicy) { e.R.SetPathPolicy(p) }
+
+// Handle registers a route; the method helpers below are shorthands for it.
+func (e *Engine) Handle(method, path string, h Handler, mws ...Middleware) error {
+	return e.R.Handle(method, path, h, mws...)
+}
+
+func (e *Engine) GET(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodGet, path, h, mws...)
+}
+func (e *Engine) POST(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodPost, path, h, mws...)
+}
+func (e *Engine) PUT(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodPut, path, h, mws...)
+}
+func (e *Engine) PATCH(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodPatch, path, h, mws...)
+}
+func (e *Engine) DELETE(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodDelete, path, h, mws...)
+}
+func (e *Engine) HEAD(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodHead, path, h, mws...)
+}
+func (e *Engine) OPTIONS(path string, h Handler, mws ...Middleware) error {
+	return e.Handle(http.MethodOptions, path, h, mws...)
+}
+
+// Any registers h for every standard method.
+func (e *Engine) Any(path string, h Handler, mws ...Middleware) error {
+	return e.Group("").Any(path, h, mws...)
+}
+
+// Mount serves an http.Handler below prefix; see Group.Mount.
+func (e *Engine) Mount(prefix string, h http.Handler, mws ...Middleware) error {
+	return e.R.Mount(prefix, h, mws...)
+}
+
+// Group starts a route group below prefix; see Router.Group.
+func (e *Engine) Group(prefix string, m ...Middleware) *Group { return e.R.Group(prefix, m...) }
+
+// Host starts a route group for requests to a host; see Router.Host.
+func (e *Engine) Host(pattern string, m ...Middleware) *Group { return e.R.Host(pattern, m...) }
 
-func (e *Engine) GET(path string, h Handler)    { _ = e.R.Handle(http.MethodGet, path, h, e.mws...) }
-func (e *Engine) POST(path string, h Handler)   { _ = e.R.Handle(http.MethodPost, path, h, e.mws...) }
-func (e *Engine) PUT(path string, h Handler)    { _ = e.R.Handle(http.MethodPut, path, h, e.mws...) }
-func (e *Engine) PATCH(path string, h Handler)  { _ = e.R.Handle(http.MethodPatch, path, h, e.mws...) }
-func (e *Engine) DELETE(path string, h Handler) { _ = e.R.Handle(http.MethodDelete, path, h, e.mws...) }
+// SetMaxBodyBytes limits request bodies for every route; RunGNet uses it unless
+// WithGNetMaxBodyBytes overrides it. n <= 0 disables the limit.
+func (e *Engine) SetMaxBodyBytes(n int64) { e.maxBodyBytes = n }
 
 // ServeHTTP just delegates to the underlying Router
-func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) { e.R.ServeHTTP(w, r) }
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if e.maxBodyBytes > 0 && !limitRequestBody(e.R, w, r, e.maxBodyBytes) {
+		return
+	}
+	e.R.ServeHTTP(w, r)
+}
 
 // Run starts a net/http server with graceful shutdown
 func (e *Engine) Run(addr string) error {
-	e.httpServer = &http.Server{Addr: addr, Handler: e, ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 15 * time.Second, IdleTimeout: 60 * time.Second}
-	go func() {
-		quit := make(chan os.Signal, 1)
-		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-		<-quit
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		if err := e.httpServer.Shutdown(ctx); err != nil {
-			log.Printf("server shutdown: %v", err)
-		}
-	}()
+	e.httpServer = e.newHTTPServer(addr)
+	go e.shutdownOnSignal()
 	log.Printf("buff listening on %s", addr)
 	return e.httpServer.ListenAndServe()
 }
+
+// RunTLS is like Run but serves HTTPS. The certificate pair is reloaded when
+// the files change on disk.
+func (e *Engine) RunTLS(addr, certFile, keyFile string) error {
+	cm := NewCertManager()
+	if err := cm.Add(certFile, keyFile); err != nil {
+		return err
+	}
+	e.httpServer = e.newHTTPServer(addr)
+	e.httpServer.TLSConfig = cm.TLSConfig()
+	go e.shutdownOnSignal()
+	log.Printf("buff listening on %s (tls)", addr)
+	return e.httpServer.ListenAndServeTLS("", "")
+}
+
+func (e *Engine) newHTTPServer(addr string) *http.Server {
+	return &http.Server{Addr: addr, Handler: e, ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 15 * time.Second, IdleTimeout: 60 * time.Second}
+}
+
+func (e *Engine) shutdownOnSignal() {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("server shutdown: %v", err)
+	}
+}
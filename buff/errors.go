@@ -0,0 +1,133 @@
+package buff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ErrHandler is a handler that reports failure by returning an error. Adapt
+// it with WrapErr; the returned error is rendered by the router's error
+// handler.
+type ErrHandler func(c *Context) error
+
+// ErrorHandler renders the errors a request recorded. err joins all of them
+// when there is more than one.
+type ErrorHandler func(c *Context, err error)
+
+// HTTPError is an error that carries the status code to respond with.
+// Message is sent to the client as is.
+type HTTPError struct {
+	Code    int
+	Message string
+}
+
+// NewHTTPError returns an HTTPError; without msg the status text is used.
+func NewHTTPError(code int, msg ...any) *HTTPError {
+	m := http.StatusText(code)
+	if len(msg) > 0 {
+		m = fmt.Sprint(msg...)
+	}
+	return &HTTPError{Code: code, Message: m}
+}
+
+func (e *HTTPError) Error() string { return fmt.Sprintf("%d: %s", e.Code, e.Message) }
+
+// BindError is returned by Bind when the request body cannot be decoded.
+type BindError struct{ Err error }
+
+func (e *BindError) Error() string { return "invalid request body: " + e.Err.Error() }
+func (e *BindError) Unwrap() error { return e.Err }
+
+// WrapErr adapts h; a non-nil error is recorded with c.Error.
+func WrapErr(h ErrHandler) Handler {
+	return func(c *Context) {
+		if err := h(c); err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// Error records err for the error handler, which runs once the route's
+// handler returns unless a response has been written by then. Nil is
+// ignored.
+func (c *Context) Error(err error) {
+	if err != nil {
+		c.errs = append(c.errs, err)
+	}
+}
+
+// Errors returns the errors recorded for the request.
+func (c *Context) Errors() []error { return c.errs }
+
+// ErrorHandler replaces the function that turns recorded errors into a
+// response. The default is DefaultErrorHandler.
+func (r *Router) ErrorHandler(h ErrorHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.edit().errorH = h
+}
+
+// renderErrors runs the error handler for errors recorded while h ran if
+// nothing has been written yet.
+func renderErrors(h Handler) Handler {
+	return func(c *Context) {
+		h(c)
+		c.renderErrors()
+	}
+}
+
+// renderErrors passes the errors recorded since the last call to the error
+// handler, so each reaches it once even though both the route's chain and
+// ServeHTTP call this.
+func (c *Context) renderErrors() {
+	errs := c.errs[c.rendered:]
+	if len(errs) == 0 || c.sw.wrote || c.router == nil {
+		return
+	}
+	c.rendered = len(c.errs)
+	eh := c.router.snapshot().errorH
+	if eh == nil {
+		eh = DefaultErrorHandler
+	}
+	if len(errs) == 1 {
+		eh(c, errs[0])
+		return
+	}
+	eh(c, errors.Join(errs...))
+}
+
+// DefaultErrorHandler maps HTTPError to its code, bind errors to 400 (413 for
+// an oversized body), timeouts to 504 and anything else to a logged 500. A
+// *Problem is sent as is.
+func DefaultErrorHandler(c *Context, err error) {
+	var p *Problem
+	if errors.As(err, &p) {
+		_ = c.Problem(*p)
+		return
+	}
+	code, msg := errorStatus(err)
+	if code == http.StatusInternalServerError {
+		log.Printf("[buff] error: %s %s: %v", c.Request.Method, c.Request.URL.Path, err)
+	}
+	_ = c.fail(code, msg)
+}
+
+func errorStatus(err error) (int, string) {
+	var he *HTTPError
+	var be *BindError
+	var mbe *http.MaxBytesError
+	switch {
+	case errors.As(err, &he):
+		return he.Code, he.Message
+	case errors.As(err, &mbe):
+		return http.StatusRequestEntityTooLarge, "request body too large"
+	case errors.As(err, &be):
+		return http.StatusBadRequest, be.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, http.ErrHandlerTimeout):
+		return http.StatusGatewayTimeout, "timeout"
+	}
+	return http.StatusInternalServerError, "internal error"
+}
@@ -0,0 +1,206 @@
+package buff
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const defaultStreamBodyBuffer = 64 << 10
+
+var (
+	errBodyReadAfterClose = errors.New("http: invalid Read on closed Body")
+	errInboundOverrun     = errors.New("buff: peer sent data faster than the handler read it")
+)
+
+// gnetBodyReader is a request body that the event loop fills while a worker
+// reads it. At most limit bytes are buffered; once full the loop stops
+// feeding until the reader drains it and wakes the connection. gnet cannot
+// stop reading the socket, so while the reader is full only backlog more
+// bytes may wait in the connection's inbound buffer before it is closed.
+type gnetBodyReader struct {
+	mu      sync.Mutex
+	cond    sync.Cond
+	buf     []byte
+	limit   int
+	backlog int
+	eof     bool
+	err     error
+	closed  bool
+	stalled bool
+	trailer http.Header
+	wake    func()
+}
+
+func newGNetBodyReader(limit int, trailer http.Header, wake func()) *gnetBodyReader {
+	b := &gnetBodyReader{limit: limit, backlog: limit, trailer: trailer, wake: wake}
+	b.cond.L = &b.mu
+	return b
+}
+
+// write is called on the event loop and returns how many bytes of p were
+// accepted. Once the handler has closed the body the data is discarded.
+func (b *gnetBodyReader) write(p []byte) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return len(p)
+	}
+	n := min(len(p), b.limit-len(b.buf))
+	if n <= 0 {
+		b.stalled = true
+		return 0
+	}
+	b.buf = append(b.buf, p[:n]...)
+	if n < len(p) {
+		b.stalled = true
+	}
+	b.cond.Broadcast()
+	return n
+}
+
+func (b *gnetBodyReader) isStalled() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stalled
+}
+
+// finish marks the body complete, publishing any chunked trailers.
+func (b *gnetBodyReader) finish(trailers http.Header) {
+	b.mu.Lock()
+	for k, vv := range trailers {
+		b.trailer[k] = append(b.trailer[k], vv...)
+	}
+	b.eof = true
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *gnetBodyReader) fail(err error) {
+	b.mu.Lock()
+	if !b.eof && b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+func (b *gnetBodyReader) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for len(b.buf) == 0 && !b.eof && b.err == nil && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		return 0, errBodyReadAfterClose
+	}
+	if len(b.buf) == 0 {
+		err := b.err
+		if err == nil {
+			err = io.EOF
+		}
+		b.mu.Unlock()
+		return 0, err
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[:copy(b.buf, b.buf[n:])]
+	resume := b.stalled
+	b.stalled = false
+	b.mu.Unlock()
+	if resume {
+		b.wake()
+	}
+	return n, nil
+}
+
+// Close discards whatever the handler left unread; the rest of the body is
+// still consumed off the wire so the connection stays usable.
+func (b *gnetBodyReader) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.buf = nil
+	resume := b.stalled
+	b.stalled = false
+	b.cond.Broadcast()
+	b.mu.Unlock()
+	if resume {
+		b.wake()
+	}
+	return nil
+}
+
+// gnetBodyFeed tracks how much of a streamed body is still on the wire.
+type gnetBodyFeed struct {
+	r         *gnetBodyReader
+	remaining int64
+	chunked   *chunkedDecoder
+	limit     int64
+	received  int64
+}
+
+// feed hands buffered connection bytes to the reader. It returns the number
+// of bytes consumed and whether the body is complete. A chunked body that
+// grows past limit fails with *http.MaxBytesError.
+func (f *gnetBodyFeed) feed(buf []byte) (int, bool, error) {
+	if f.chunked != nil {
+		tooLarge := false
+		n, err := f.chunked.decode(buf, func(p []byte) int {
+			if f.limit > 0 && f.received+int64(len(p)) > f.limit {
+				tooLarge = true
+				return 0
+			}
+			accepted := f.r.write(p)
+			f.received += int64(accepted)
+			return accepted
+		})
+		if tooLarge {
+			return n, false, &http.MaxBytesError{Limit: f.limit}
+		}
+		if err != nil {
+			return n, false, err
+		}
+		if f.chunked.done() {
+			f.r.finish(f.chunked.trailers)
+			return n, true, nil
+		}
+		return n, false, nil
+	}
+	chunk := buf
+	if int64(len(chunk)) > f.remaining {
+		chunk = chunk[:f.remaining]
+	}
+	n := f.r.write(chunk)
+	f.remaining -= int64(n)
+	if f.remaining == 0 {
+		f.r.finish(nil)
+		return n, true, nil
+	}
+	return n, false, nil
+}
+
+// beginBodyStream parses the request head in buf and, if the body has not
+// fully arrived, attaches a streaming reader to the request; wake is called
+// once the reader has room again. It returns errNeedMoreData when the head
+// itself is incomplete or there is no body to stream.
+func (h *gnetHTTPHandler) beginBodyStream(buf []byte, wake func()) (*http.Request, int, *gnetBodyFeed, error) {
+	req, bodyStart, err := parseRequestHead(buf, h.maxHeaderBytes)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if req.ContentLength == 0 {
+		return nil, 0, nil, errNeedMoreData
+	}
+	feed := &gnetBodyFeed{remaining: req.ContentLength, limit: h.maxBodyBytes}
+	if req.ContentLength < 0 {
+		feed.chunked = &chunkedDecoder{}
+		req.Trailer = http.Header{}
+	}
+	feed.r = newGNetBodyReader(h.streamBodyBuffer, req.Trailer, wake)
+	req.Body = feed.r
+	return req, bodyStart, feed, nil
+}
@@ -1,6 +1,7 @@
 package buff
 
 import (
+	"crypto/tls"
 	"os"
 	"syscall"
 	"time"
@@ -16,10 +17,17 @@ const (
 
 type gnetRunConfig struct {
 	maxHeaderBytes  int
+	maxBodyBytes    int64
 	shutdownSignals []os.Signal
 	shutdownTimeout time.Duration
 	opts            []gnet.Option
 	serverHeader    string
+	workerPoolSize  int
+	workerQueueSize int
+
+	streamBodyBuffer int
+	tlsConfig        *tls.Config
+	h2c              bool
 }
 
 func defaultGNetRunConfig() gnetRunConfig {
@@ -43,6 +51,16 @@ func WithGNetMaxHeaderBytes(n int) GNetRunOption {
 	}
 }
 
+// WithGNetMaxBodyBytes sets the maximum allowed body size for incoming requests.
+// Larger requests are rejected with 413 as soon as the excess is detected.
+func WithGNetMaxBodyBytes(n int64) GNetRunOption {
+	return func(cfg *gnetRunConfig) {
+		if n > 0 {
+			cfg.maxBodyBytes = n
+		}
+	}
+}
+
 // WithGNetShutdownSignals overrides the OS signals that trigger graceful shutdown.
 func WithGNetShutdownSignals(signals ...os.Signal) GNetRunOption {
 	return func(cfg *gnetRunConfig) {
@@ -70,6 +88,50 @@ func WithGNetServerHeader(header string) GNetRunOption {
 	}
 }
 
+// WithGNetWorkerPool runs handlers on a bounded goroutine pool instead of the event loop.
+// size caps concurrently running handlers and queue caps connections waiting for a worker;
+// requests beyond that are answered with 503.
+func WithGNetWorkerPool(size, queue int) GNetRunOption {
+	return func(cfg *gnetRunConfig) {
+		if size > 0 {
+			cfg.workerPoolSize = size
+			cfg.workerQueueSize = max(queue, 0)
+		}
+	}
+}
+
+// WithGNetStreamRequestBody dispatches requests as soon as their headers are parsed and
+// feeds the body to the handler as it arrives. The handler's reader buffers at most
+// bufSize bytes; once it is full, at most another bufSize bytes are held back for it
+// and a client that sends more before the handler reads is disconnected. The same
+// bound applies to hijacked connections. It requires WithGNetWorkerPool.
+func WithGNetStreamRequestBody(bufSize int) GNetRunOption {
+	return func(cfg *gnetRunConfig) {
+		if bufSize <= 0 {
+			bufSize = defaultStreamBodyBuffer
+		}
+		cfg.streamBodyBuffer = bufSize
+	}
+}
+
+// WithGNetTLS terminates TLS on every connection. Use CertManager.GetCertificate
+// in cfg for SNI-based selection and certificate reload from disk. Each
+// connection's record layer runs on its own goroutine; WithGNetWorkerPool and
+// WithGNetStreamRequestBody apply to its requests as they do without TLS.
+func WithGNetTLS(cfg *tls.Config) GNetRunOption {
+	return func(c *gnetRunConfig) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithGNetH2C serves HTTP/2 over cleartext TCP, both to clients that open with
+// the HTTP/2 preface and to HTTP/1.1 requests carrying Upgrade: h2c.
+func WithGNetH2C() GNetRunOption {
+	return func(cfg *gnetRunConfig) {
+		cfg.h2c = true
+	}
+}
+
 // WithGNetOption forwards a gnet.Option to the underlying event engine.
 func WithGNetOption(opt gnet.Option) GNetRunOption {
 	return func(cfg *gnetRunConfig) {
@@ -1,7 +1,50 @@
 package buff
 
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
 type gnetConnContext struct {
 	buf []byte
+
+	// reqCtx is canceled when the connection closes so handlers running on a
+	// worker can notice the client going away.
+	reqCtx context.Context
+	cancel context.CancelFunc
+
+	// feed is set while a streamed request body is still arriving.
+	feed *gnetBodyFeed
+	// tunnel is set after an upgrade request; from then on inbound bytes
+	// bypass the HTTP parser.
+	tunnel *gnetTunnelConn
+	// parsed is set once a request has been read; the HTTP/2 preface is only
+	// recognized before that.
+	parsed bool
+
+	// worker pool mode: requests parsed on the event loop wait here until the
+	// connection's single in-flight task picks them up, preserving order.
+	// Parsing pauses while gnetMaxPendingRequests are waiting.
+	mu      sync.Mutex
+	pending []gnetPendingRequest
+	running bool
+	closing bool
+	paused  bool
+}
+
+// gnetMaxPendingRequests caps the pipelined requests queued per connection.
+const gnetMaxPendingRequests = 16
+
+type gnetPendingRequest struct {
+	req        *http.Request
+	closeAfter bool
+	tunnel     *gnetTunnelConn
+	// h2c is set when the request upgrades the connection to HTTP/2;
+	// h2cSettings holds its decoded HTTP2-Settings payload.
+	h2c         bool
+	h2cSettings []byte
 }
 
 func (g *gnetConnContext) append(p []byte) {
@@ -18,6 +61,126 @@ func (g *gnetConnContext) discard(n int) {
 	}
 }
 
+// stalledReader returns the body or tunnel reader that is full and waiting
+// for its consumer, or nil.
+func (g *gnetConnContext) stalledReader() *gnetBodyReader {
+	switch {
+	case g.feed != nil && g.feed.r.isStalled():
+		return g.feed.r
+	case g.tunnel != nil && g.tunnel.r.isStalled():
+		return g.tunnel.r
+	}
+	return nil
+}
+
+// requestContext returns the context handed to requests on this connection.
+func (g *gnetConnContext) requestContext() context.Context {
+	if g.reqCtx == nil {
+		g.reqCtx, g.cancel = context.WithCancel(context.Background())
+	}
+	return g.reqCtx
+}
+
 func (g *gnetConnContext) reset() {
 	g.buf = nil
+	if g.cancel != nil {
+		g.cancel()
+	}
+	if g.feed != nil {
+		g.feed.r.fail(io.ErrUnexpectedEOF)
+		g.feed = nil
+	}
+	if g.tunnel != nil {
+		g.tunnel.r.fail(io.EOF)
+		g.tunnel = nil
+	}
+	g.markClosing()
+}
+
+// enqueue adds p to the pending queue and reports whether the caller must
+// start a new task to drain it.
+func (g *gnetConnContext) enqueue(p gnetPendingRequest) (start, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing {
+		return false, false
+	}
+	g.pending = append(g.pending, p)
+	if p.closeAfter {
+		g.closing = true
+	}
+	if g.running {
+		return false, true
+	}
+	g.running = true
+	return true, true
+}
+
+// dequeue pops the next pending request, clearing running when none is left.
+// When the queue is empty, closing reports whether the connection is going
+// away and the exiting task must close it.
+func (g *gnetConnContext) dequeue() (p gnetPendingRequest, ok, closing bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.pending) == 0 {
+		g.running = false
+		return gnetPendingRequest{}, false, g.closing
+	}
+	p = g.pending[0]
+	g.pending[0] = gnetPendingRequest{}
+	g.pending = g.pending[1:]
+	return p, true, false
+}
+
+// queueFull reports whether no more requests may be queued; if so parsing
+// pauses until unpause.
+func (g *gnetConnContext) queueFull() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.pending) >= gnetMaxPendingRequests {
+		g.paused = true
+	}
+	return g.paused
+}
+
+// unpause reports whether parsing was paused and the queue has room again;
+// the caller must then wake the connection.
+func (g *gnetConnContext) unpause() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.paused || len(g.pending) >= gnetMaxPendingRequests {
+		return false
+	}
+	g.paused = false
+	return true
+}
+
+func (g *gnetConnContext) isPaused() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.paused
+}
+
+// markClosing drops any queued requests once the connection is going away
+// and reports whether no task is running that would close it later.
+func (g *gnetConnContext) markClosing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closing = true
+	g.pending = nil
+	return !g.running
+}
+
+// closeAfterDrain reports whether the connection is closing and the
+// response just produced is the last one it owes.
+func (g *gnetConnContext) closeAfterDrain() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closing && len(g.pending) == 0
+}
+
+func (g *gnetConnContext) isClosing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closing
 }
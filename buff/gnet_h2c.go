@@ -0,0 +1,79 @@
+package buff
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+
+	gnet "github.com/panjf2000/gnet/v2"
+	"golang.org/x/net/http2"
+)
+
+// h2Preface is the client connection preface that opens an HTTP/2
+// connection with prior knowledge (RFC 9113 section 3.4).
+var h2Preface = []byte(http2.ClientPreface)
+
+// h2cUploadWindow is the connection-level flow control window advertised to
+// h2c clients. A client may send that much before the server reads any of it,
+// so the tunnel lets it wait on top of its own buffer.
+const h2cUploadWindow = 1 << 20
+
+const h2cSwitchingProtocols = "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"
+
+// h2cPrefaceState reports whether buf starts with the HTTP/2 preface and,
+// if not, whether it is still too short to tell.
+func h2cPrefaceState(buf []byte) (isPreface, needMore bool) {
+	if len(buf) < len(h2Preface) {
+		return false, bytes.HasPrefix(h2Preface, buf)
+	}
+	return bytes.HasPrefix(buf, h2Preface), false
+}
+
+// h2cUpgradeSettings returns the decoded HTTP2-Settings payload when req asks
+// to switch to h2c (RFC 7540 section 3.2). ok is false for any other request.
+func h2cUpgradeSettings(req *http.Request) (settings []byte, ok bool) {
+	if !headerHasToken(req.Header, "Upgrade", "h2c") || !headerHasToken(req.Header, "Connection", "HTTP2-Settings") {
+		return nil, false
+	}
+	values := req.Header.Values("HTTP2-Settings")
+	if len(values) != 1 {
+		return nil, false
+	}
+	settings, err := base64.RawURLEncoding.DecodeString(values[0])
+	if err != nil {
+		return nil, false
+	}
+	return settings, true
+}
+
+func (h *gnetHTTPHandler) newH2CTunnel(c gnet.Conn) *gnetTunnelConn {
+	t := newGNetTunnelConn(c, h.tunnelBufferSize())
+	t.r.backlog += h2cUploadWindow
+	return t
+}
+
+// serveH2C runs an HTTP/2 server connection over conn. upgrade is the
+// HTTP/1.1 request that switched protocols; it becomes stream 1. Each stream
+// runs on its own goroutine and goes through the same router as HTTP/1.1.
+func (h *gnetHTTPHandler) serveH2C(conn net.Conn, connCtx context.Context, upgrade *http.Request, settings []byte) {
+	defer conn.Close()
+	h.h2.ServeConn(conn, &http2.ServeConnOpts{
+		Context:        connCtx,
+		BaseConfig:     &http.Server{MaxHeaderBytes: h.maxHeaderBytes},
+		Handler:        http.HandlerFunc(h.serveH2Stream),
+		UpgradeRequest: upgrade,
+		Settings:       settings,
+	})
+}
+
+func (h *gnetHTTPHandler) serveH2Stream(w http.ResponseWriter, r *http.Request) {
+	if h.serverHeader != "" {
+		w.Header().Set("Server", h.serverHeader)
+	}
+	if h.maxBodyBytes > 0 && !limitRequestBody(h.router, w, r, h.maxBodyBytes) {
+		return
+	}
+	h.router.ServeHTTP(w, r)
+}
@@ -2,9 +2,13 @@ package buff
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,6 +16,7 @@ import (
 
 	gnet "github.com/panjf2000/gnet/v2"
 	"github.com/valyala/bytebufferpool"
+	"golang.org/x/net/http2"
 )
 
 type gnetHTTPHandler struct {
@@ -19,23 +24,53 @@ type gnetHTTPHandler struct {
 
 	router          *Router
 	maxHeaderBytes  int
+	maxBodyBytes    int64
 	shutdownSignals []os.Signal
 	shutdownTimeout time.Duration
 	serverHeader    string
 
+	streamBodyBuffer int
+	tlsConfig        *tls.Config
+	h2               *http2.Server
+
 	engine  gnet.Engine
 	bufPool *bytebufferpool.Pool
+	workers *gnetWorkerPool
 }
 
-func newGNetHTTPHandler(r *Router, cfg gnetRunConfig) *gnetHTTPHandler {
-	return &gnetHTTPHandler{
+func newGNetHTTPHandler(r *Router, cfg gnetRunConfig) (*gnetHTTPHandler, error) {
+	h := &gnetHTTPHandler{
 		router:          r,
 		maxHeaderBytes:  cfg.maxHeaderBytes,
+		maxBodyBytes:    cfg.maxBodyBytes,
 		shutdownSignals: cfg.shutdownSignals,
 		shutdownTimeout: cfg.shutdownTimeout,
 		serverHeader:    cfg.serverHeader,
 		bufPool:         &bytebufferpool.Pool{},
 	}
+	if cfg.streamBodyBuffer > 0 {
+		if cfg.workerPoolSize <= 0 {
+			return nil, errors.New("streaming request bodies require WithGNetWorkerPool")
+		}
+		h.streamBodyBuffer = cfg.streamBodyBuffer
+	}
+	if cfg.tlsConfig != nil {
+		h.tlsConfig = cfg.tlsConfig.Clone()
+		if len(h.tlsConfig.NextProtos) == 0 {
+			h.tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+	}
+	if cfg.h2c {
+		h.h2 = &http2.Server{MaxUploadBufferPerConnection: h2cUploadWindow}
+	}
+	if cfg.workerPoolSize > 0 {
+		wp, err := newGNetWorkerPool(cfg.workerPoolSize, cfg.workerQueueSize)
+		if err != nil {
+			return nil, err
+		}
+		h.workers = wp
+	}
+	return h, nil
 }
 
 func (h *gnetHTTPHandler) OnBoot(engine gnet.Engine) (action gnet.Action) {
@@ -46,6 +81,15 @@ func (h *gnetHTTPHandler) OnBoot(engine gnet.Engine) (action gnet.Action) {
 	return gnet.None
 }
 
+func (h *gnetHTTPHandler) OnShutdown(engine gnet.Engine) {
+	if h.workers == nil {
+		return
+	}
+	if err := h.workers.release(h.shutdownTimeout); err != nil {
+		log.Printf("[buff] gnet worker pool release: %v", err)
+	}
+}
+
 func (h *gnetHTTPHandler) handleSignals() {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, h.shutdownSignals...)
@@ -63,7 +107,14 @@ func (h *gnetHTTPHandler) handleSignals() {
 }
 
 func (h *gnetHTTPHandler) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
-	c.SetContext(&gnetConnContext{})
+	ctx := &gnetConnContext{}
+	c.SetContext(ctx)
+	if h.tlsConfig != nil {
+		// TLS connections are tunneled as a whole: the loop only shuttles
+		// ciphertext and serveTLS runs the record layer and HTTP on its own goroutine.
+		ctx.tunnel = newGNetTunnelConn(c, tlsTunnelBuffer)
+		go h.serveTLS(ctx.tunnel, ctx.requestContext())
+	}
 	return nil, gnet.None
 }
 
@@ -81,6 +132,26 @@ func (h *gnetHTTPHandler) OnTraffic(c gnet.Conn) gnet.Action {
 		c.SetContext(ctx)
 	}
 
+	if r := ctx.stalledReader(); r != nil {
+		// Leave new bytes in gnet's inbound buffer until the handler catches
+		// up. gnet keeps reading the socket into that buffer regardless, so a
+		// peer that runs too far ahead of the handler is cut off.
+		if c.InboundBuffered() > r.backlog {
+			r.fail(errInboundOverrun)
+			return gnet.Close
+		}
+		return gnet.None
+	}
+
+	if ctx.isPaused() {
+		// Too many requests are queued; the rest waits for the worker, which
+		// wakes the connection. Bound it like a stalled body.
+		if c.InboundBuffered() > h.tunnelBufferSize() {
+			return gnet.Close
+		}
+		return gnet.None
+	}
+
 	if n := c.InboundBuffered(); n > 0 {
 		data, err := c.Next(n)
 		if err != nil {
@@ -90,37 +161,138 @@ func (h *gnetHTTPHandler) OnTraffic(c gnet.Conn) gnet.Action {
 		ctx.append(data)
 	}
 
+	if h.workers != nil && ctx.feed == nil && ctx.tunnel == nil && ctx.isClosing() {
+		ctx.discard(len(ctx.buf))
+		return gnet.None
+	}
+
 	for len(ctx.buf) > 0 {
-		req, consumed, closeAfter, err := parseHTTPRequest(ctx.buf, h.maxHeaderBytes)
+		if ctx.tunnel != nil {
+			ctx.discard(ctx.tunnel.r.write(ctx.buf))
+			return gnet.None
+		}
+		if ctx.feed != nil {
+			n, done, err := ctx.feed.feed(ctx.buf)
+			ctx.discard(n)
+			if err != nil {
+				ctx.feed.r.fail(err)
+				ctx.feed = nil
+				ctx.discard(len(ctx.buf))
+				if ctx.markClosing() {
+					return gnet.Close
+				}
+				return gnet.None
+			}
+			if !done {
+				return gnet.None
+			}
+			ctx.feed = nil
+			continue
+		}
+
+		if h.h2 != nil && !ctx.parsed {
+			isPreface, needMore := h2cPrefaceState(ctx.buf)
+			if needMore {
+				break
+			}
+			if isPreface {
+				ctx.tunnel = h.newH2CTunnel(c)
+				go h.serveH2C(ctx.tunnel, ctx.requestContext(), nil, nil)
+				continue
+			}
+		}
+
+		if h.workers != nil && ctx.queueFull() {
+			break
+		}
+
+		req, consumed, closeAfter, err := parseHTTPRequest(ctx.buf, h.maxHeaderBytes, h.maxBodyBytes)
+		if errors.Is(err, errNeedMoreData) && h.streamBodyBuffer > 0 {
+			req, consumed, ctx.feed, err = h.beginBodyStream(ctx.buf, func() { _ = c.Wake(nil) })
+			if err == nil {
+				closeAfter = req.Close
+			}
+		}
 		if err != nil {
 			if errors.Is(err, errNeedMoreData) {
 				break
 			}
-			if errors.Is(err, errHeaderTooLarge) {
+			switch {
+			case errors.Is(err, errHeaderTooLarge):
 				h.writeError(c, http.StatusRequestHeaderFieldsTooLarge, err.Error())
-			} else {
+			case errors.Is(err, errBodyTooLarge):
+				h.writeError(c, http.StatusRequestEntityTooLarge, err.Error())
+			default:
 				h.writeError(c, http.StatusBadRequest, err.Error())
 			}
 			return gnet.Close
 		}
 
 		req.RemoteAddr = c.RemoteAddr().String()
+		ctx.parsed = true
+
+		var h2cSettings []byte
+		h2cUpgrade := false
+		if h.h2 != nil && ctx.feed == nil {
+			h2cSettings, h2cUpgrade = h2cUpgradeSettings(req)
+		}
+
+		if h.workers != nil {
+			req = req.WithContext(ctx.requestContext())
+			detachRequestBody(req)
+			ctx.discard(consumed)
+			p := gnetPendingRequest{req: req, closeAfter: closeAfter}
+			if h2cUpgrade {
+				p.tunnel = h.newH2CTunnel(c)
+				p.h2c, p.h2cSettings = true, h2cSettings
+				ctx.tunnel = p.tunnel
+			} else if isUpgradeRequest(req.Header) {
+				p.tunnel = newGNetTunnelConn(c, h.tunnelBufferSize())
+				ctx.tunnel = p.tunnel
+			}
+			if !h.dispatch(c, ctx, p) {
+				ctx.feed = nil
+				ctx.tunnel = nil
+				ctx.discard(len(ctx.buf))
+				return gnet.None
+			}
+			if closeAfter && ctx.feed == nil {
+				ctx.discard(len(ctx.buf))
+				return gnet.None
+			}
+			continue
+		}
+
+		if h2cUpgrade {
+			req = req.WithContext(ctx.requestContext())
+			detachRequestBody(req)
+			ctx.discard(consumed)
+			if _, err := c.Write([]byte(h2cSwitchingProtocols)); err != nil {
+				return gnet.Close
+			}
+			ctx.tunnel = h.newH2CTunnel(c)
+			go h.serveH2C(ctx.tunnel, req.Context(), req, h2cSettings)
+			continue
+		}
 
-		writer := acquireGNetResponseWriter(h.bufPool)
-		writer.serverHdr = h.serverHeader
-		h.router.ServeHTTP(writer, req)
-		_ = req.Body.Close()
+		if isUpgradeRequest(req.Header) {
+			// A handler that takes over the connection would block the event
+			// loop, so upgrades run on their own goroutine and every later
+			// byte goes to the tunnel it may hijack.
+			req = req.WithContext(ctx.requestContext())
+			detachRequestBody(req)
+			ctx.discard(consumed)
+			ctx.tunnel = newGNetTunnelConn(c, h.tunnelBufferSize())
+			go h.serveUpgrade(c, req, ctx.tunnel)
+			continue
+		}
 
-		respBuf := h.bufPool.Get()
-		respBuf.Reset()
-		respBuf, shouldClose := writer.finalize(req, closeAfter, respBuf)
+		respBuf, shouldClose, _ := h.serve(req, closeAfter, h.writeSync(c), nil, true)
 		if _, err := c.Write(respBuf.Bytes()); err != nil {
 			h.bufPool.Put(respBuf)
-			releaseGNetResponseWriter(h.bufPool, writer)
 			return gnet.Close
 		}
 		h.bufPool.Put(respBuf)
-		releaseGNetResponseWriter(h.bufPool, writer)
 
 		ctx.discard(consumed)
 
@@ -131,15 +303,164 @@ func (h *gnetHTTPHandler) OnTraffic(c gnet.Conn) gnet.Action {
 	return gnet.None
 }
 
-func (h *gnetHTTPHandler) writeError(c gnet.Conn, status int, msg string) {
+// serve runs req through the router and returns the encoded response, which
+// the caller must put back into bufPool once written. Data flushed by the
+// handler before it returns is handed to send as it is produced. When the
+// handler hijacked conn the response is empty and hijacked is true; a nil
+// conn disables Hijack. inline marks a handler running on the event loop.
+func (h *gnetHTTPHandler) serve(req *http.Request, closeAfter bool, send func(*bytebufferpool.ByteBuffer) error, conn net.Conn, inline bool) (respBuf *bytebufferpool.ByteBuffer, shouldClose, hijacked bool) {
+	writer := acquireGNetResponseWriter(h.bufPool)
+	writer.serverHdr = h.serverHeader
+	writer.req, writer.reqClose, writer.send = req, closeAfter, send
+	writer.conn, writer.inline = conn, inline
+	h.router.ServeHTTP(writer, req)
+	_ = req.Body.Close()
+
+	respBuf = h.bufPool.Get()
+	respBuf.Reset()
+	hijacked = writer.hijacked
+	if !hijacked {
+		respBuf, shouldClose = writer.finalize(req, closeAfter, respBuf)
+	}
+	releaseGNetResponseWriter(h.bufPool, writer)
+	return respBuf, shouldClose, hijacked
+}
+
+// serveUpgrade serves an upgrade request on a goroutine of its own. Unless
+// the handler hijacks the tunnel the connection is closed after the
+// response, as the bytes that followed were never parsed as HTTP.
+func (h *gnetHTTPHandler) serveUpgrade(c gnet.Conn, req *http.Request, tunnel *gnetTunnelConn) {
+	respBuf, _, hijacked := h.serve(req, true, h.writeAsync(c), tunnel, false)
+	if hijacked {
+		h.bufPool.Put(respBuf)
+		return
+	}
+	err := c.AsyncWrite(respBuf.Bytes(), func(c gnet.Conn, err error) error {
+		h.bufPool.Put(respBuf)
+		return c.Close()
+	})
+	if err != nil {
+		h.bufPool.Put(respBuf)
+		_ = c.Close()
+	}
+}
+
+func (h *gnetHTTPHandler) tunnelBufferSize() int {
+	if h.streamBodyBuffer > 0 {
+		return h.streamBodyBuffer
+	}
+	return defaultStreamBodyBuffer
+}
+
+// writeSync writes buf on the event loop; used when handlers run inline.
+func (h *gnetHTTPHandler) writeSync(c gnet.Conn) func(*bytebufferpool.ByteBuffer) error {
+	return func(buf *bytebufferpool.ByteBuffer) error {
+		_, err := c.Write(buf.Bytes())
+		h.bufPool.Put(buf)
+		return err
+	}
+}
+
+// writeAsync queues buf on the connection from a worker goroutine.
+func (h *gnetHTTPHandler) writeAsync(c gnet.Conn) func(*bytebufferpool.ByteBuffer) error {
+	return func(buf *bytebufferpool.ByteBuffer) error {
+		err := c.AsyncWrite(buf.Bytes(), func(gnet.Conn, error) error {
+			h.bufPool.Put(buf)
+			return nil
+		})
+		if err != nil {
+			h.bufPool.Put(buf)
+		}
+		return err
+	}
+}
+
+// dispatch queues req on the connection and, if no task is draining it yet,
+// hands one to the worker pool. It reports false when the request was refused.
+func (h *gnetHTTPHandler) dispatch(c gnet.Conn, ctx *gnetConnContext, p gnetPendingRequest) bool {
+	start, ok := ctx.enqueue(p)
+	if !ok {
+		return false
+	}
+	if !start {
+		return true
+	}
+	if err := h.workers.submit(func() { h.drain(c, ctx) }); err != nil {
+		ctx.markClosing()
+		h.writeError(c, http.StatusServiceUnavailable, err.Error())
+		_ = c.Close()
+		return false
+	}
+	return true
+}
+
+// drain serves the connection's queued requests one at a time on a worker
+// goroutine. Responses go out through AsyncWrite, which the event loop applies
+// in submission order, so pipelined responses keep their request order.
+func (h *gnetHTTPHandler) drain(c gnet.Conn, ctx *gnetConnContext) {
+	for {
+		p, ok, closing := ctx.dequeue()
+		if !ok {
+			if closing {
+				_ = c.Close()
+			}
+			return
+		}
+		if ctx.unpause() {
+			_ = c.Wake(nil)
+		}
+		if p.h2c {
+			// Nothing follows an h2c upgrade in the queue: every later byte
+			// goes to the tunnel, so the HTTP/2 server can take over here.
+			if err := c.AsyncWrite([]byte(h2cSwitchingProtocols), nil); err != nil {
+				ctx.markClosing()
+				continue
+			}
+			go h.serveH2C(p.tunnel, p.req.Context(), p.req, p.h2cSettings)
+			continue
+		}
+		if p.tunnel != nil {
+			// Like h2c, an upgrade is the last request on the connection. Its
+			// handler may keep the connection for as long as it lives, so it
+			// gets a goroutine of its own rather than holding a worker.
+			go h.serveUpgrade(c, p.req, p.tunnel)
+			continue
+		}
+		respBuf, shouldClose, _ := h.serve(p.req, p.closeAfter, h.writeAsync(c), nil, false)
+		if !shouldClose && ctx.closeAfterDrain() {
+			shouldClose = true
+		}
+		if shouldClose {
+			ctx.markClosing()
+		}
+		err := c.AsyncWrite(respBuf.Bytes(), func(c gnet.Conn, err error) error {
+			h.bufPool.Put(respBuf)
+			if shouldClose {
+				return c.Close()
+			}
+			return nil
+		})
+		if err != nil {
+			h.bufPool.Put(respBuf)
+			ctx.markClosing()
+		}
+	}
+}
+
+func (h *gnetHTTPHandler) writeError(c io.Writer, status int, msg string) {
 	if msg == "" {
 		msg = http.StatusText(status)
 	}
-	body := []byte(msg + "\n")
+	body, ctype := []byte(msg+"\n"), "text/plain; charset=utf-8"
+	if h.router.problemDetails() {
+		// No request was parsed, so there is no instance to report.
+		p, _ := json.Marshal(Problem{Status: status, Detail: msg}.normalized(""))
+		body, ctype = append(p, '\n'), problemContentType
+	}
 	buf := h.bufPool.Get()
 	buf.Reset()
 	fmt.Fprintf(buf, "HTTP/1.1 %d %s%s", status, http.StatusText(status), crlf)
-	fmt.Fprintf(buf, "Content-Type: text/plain; charset=utf-8%s", crlf)
+	fmt.Fprintf(buf, "Content-Type: %s%s", ctype, crlf)
 	fmt.Fprintf(buf, "Content-Length: %d%s", len(body), crlf)
 	buf.WriteString("Connection: close\r\n")
 	buf.WriteString(crlf)
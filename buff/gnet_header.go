@@ -29,7 +29,16 @@ func connectionCloseRequested(hdr http.Header) bool {
 }
 
 func hasConnectionToken(hdr http.Header, token string) bool {
-	for _, v := range hdr.Values("Connection") {
+	return headerHasToken(hdr, "Connection", token)
+}
+
+// isUpgradeRequest reports whether the request asks to switch to WebSocket.
+func isUpgradeRequest(hdr http.Header) bool {
+	return hasConnectionToken(hdr, "upgrade") && headerHasToken(hdr, "Upgrade", "websocket")
+}
+
+func headerHasToken(hdr http.Header, key, token string) bool {
+	for _, v := range hdr.Values(key) {
 		parts := strings.Split(v, ",")
 		for _, p := range parts {
 			if strings.EqualFold(strings.TrimSpace(p), token) {
@@ -10,4 +10,5 @@ const (
 var (
 	errNeedMoreData   = errors.New("incomplete http request")
 	errHeaderTooLarge = errors.New("request header too large")
+	errBodyTooLarge   = errors.New("request body too large")
 )
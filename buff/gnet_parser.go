@@ -17,9 +17,54 @@ var (
 	headerSeparatorBuf = []byte(headerBodySeparator)
 )
 
-func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool, error) {
+// parseHTTPRequest parses one complete request from buf. maxBodyBytes <= 0
+// disables the body size limit.
+func parseHTTPRequest(buf []byte, maxHeaderBytes int, maxBodyBytes int64) (*http.Request, int, bool, error) {
+	req, bodyStart, err := parseRequestHead(buf, maxHeaderBytes)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if maxBodyBytes > 0 && req.ContentLength > maxBodyBytes {
+		return nil, 0, false, errBodyTooLarge
+	}
+
+	total := bodyStart
+	if req.ContentLength < 0 {
+		data, consumed, trailers, err := parseChunkedBody(buf, bodyStart, maxBodyBytes)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		for k, vv := range trailers {
+			for _, v := range vv {
+				req.Header.Add(k, v)
+			}
+		}
+		total = consumed
+		if len(data) > 0 {
+			req.Body = io.NopCloser(bytes.NewReader(data))
+		}
+	} else {
+		if req.ContentLength > int64(len(buf)) {
+			return nil, 0, false, errNeedMoreData
+		}
+		length := int(req.ContentLength)
+		total = bodyStart + length
+		if len(buf) < total {
+			return nil, 0, false, errNeedMoreData
+		}
+		if length > 0 {
+			req.Body = io.NopCloser(bytes.NewReader(buf[bodyStart:total]))
+		}
+	}
+	return req, total, req.Close, nil
+}
+
+// parseRequestHead parses the request line and headers. The returned request
+// has an empty body; ContentLength is -1 for chunked requests. The second
+// result is the offset at which the body starts.
+func parseRequestHead(buf []byte, maxHeaderBytes int) (*http.Request, int, error) {
 	if len(buf) == 0 {
-		return nil, 0, false, errNeedMoreData
+		return nil, 0, errNeedMoreData
 	}
 	if maxHeaderBytes <= 0 {
 		maxHeaderBytes = defaultMaxHeaderBytes
@@ -27,34 +72,34 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 	headerEnd := bytes.Index(buf, headerSeparatorBuf)
 	if headerEnd == -1 {
 		if len(buf) > maxHeaderBytes {
-			return nil, 0, false, errHeaderTooLarge
+			return nil, 0, errHeaderTooLarge
 		}
-		return nil, 0, false, errNeedMoreData
+		return nil, 0, errNeedMoreData
 	}
 	if headerEnd > maxHeaderBytes {
-		return nil, 0, false, errHeaderTooLarge
+		return nil, 0, errHeaderTooLarge
 	}
 
 	lines := bytes.Split(buf[:headerEnd], crlfBytes)
 	if len(lines) == 0 {
-		return nil, 0, false, fmt.Errorf("empty request line")
+		return nil, 0, fmt.Errorf("empty request line")
 	}
 	requestLine := string(lines[0])
 	parts := strings.SplitN(requestLine, " ", 3)
 	if len(parts) != 3 {
-		return nil, 0, false, fmt.Errorf("invalid request line: %q", requestLine)
+		return nil, 0, fmt.Errorf("invalid request line: %q", requestLine)
 	}
 	method, target, proto := parts[0], parts[1], parts[2]
 	if method == "" {
-		return nil, 0, false, fmt.Errorf("missing method")
+		return nil, 0, fmt.Errorf("missing method")
 	}
 	if !strings.HasPrefix(strings.ToUpper(proto), "HTTP/") {
-		return nil, 0, false, fmt.Errorf("invalid proto: %s", proto)
+		return nil, 0, fmt.Errorf("invalid proto: %s", proto)
 	}
 
 	maj, min, err := parseHTTPVersion(proto)
 	if err != nil {
-		return nil, 0, false, err
+		return nil, 0, err
 	}
 
 	header := make(http.Header, len(lines)-1)
@@ -64,7 +109,7 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 		}
 		colon := bytes.IndexByte(line, ':')
 		if colon <= 0 {
-			return nil, 0, false, fmt.Errorf("malformed header line: %q", string(line))
+			return nil, 0, fmt.Errorf("malformed header line: %q", string(line))
 		}
 		key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(string(line[:colon])))
 		val := strings.TrimSpace(string(line[colon+1:]))
@@ -73,56 +118,23 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 
 	chunked, err := hasChunkedEncoding(header)
 	if err != nil {
-		return nil, 0, false, err
+		return nil, 0, err
 	}
 
 	if chunked && header.Get("Content-Length") != "" {
-		return nil, 0, false, fmt.Errorf("chunked request must not include Content-Length")
+		return nil, 0, fmt.Errorf("chunked request must not include Content-Length")
 	}
 
-	sepLen := len(headerSeparatorBuf)
-	bodyStart := headerEnd + sepLen
-	total := bodyStart
-	var body io.ReadCloser = http.NoBody
-	contentLength := int64(0)
-
-	if chunked {
-		data, consumed, trailers, err := parseChunkedBody(buf, bodyStart)
-		if err != nil {
-			return nil, 0, false, err
-		}
-		for k, vv := range trailers {
-			for _, v := range vv {
-				header.Add(k, v)
-			}
-		}
-		total = consumed
-		if len(data) > 0 {
-			body = io.NopCloser(bytes.NewReader(data))
-		}
-		contentLength = -1
-	} else {
-		length := 0
+	contentLength := int64(-1)
+	if !chunked {
+		contentLength = 0
 		if cl := header.Get("Content-Length"); cl != "" {
 			clVal, err := strconv.ParseInt(cl, 10, 64)
 			if err != nil || clVal < 0 {
-				return nil, 0, false, fmt.Errorf("invalid Content-Length: %q", cl)
-			}
-			if clVal > int64(len(buf)) {
-				return nil, 0, false, errNeedMoreData
+				return nil, 0, fmt.Errorf("invalid Content-Length: %q", cl)
 			}
-			length = int(clVal)
+			contentLength = clVal
 		}
-
-		total = bodyStart + length
-		if len(buf) < total {
-			return nil, 0, false, errNeedMoreData
-		}
-
-		if length > 0 {
-			body = io.NopCloser(bytes.NewReader(buf[bodyStart:total]))
-		}
-		contentLength = int64(length)
 	}
 
 	requestURI := target
@@ -131,7 +143,7 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 	}
 	parsedURL, err := parseRequestURL(requestURI, header.Get("Host"))
 	if err != nil {
-		return nil, 0, false, err
+		return nil, 0, err
 	}
 
 	req := &http.Request{
@@ -140,8 +152,8 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 		ProtoMajor:    maj,
 		ProtoMinor:    min,
 		Header:        header,
-		Body:          body,
-		ContentLength: int64(contentLength),
+		Body:          http.NoBody,
+		ContentLength: contentLength,
 		Host:          header.Get("Host"),
 		RequestURI:    requestURI,
 	}
@@ -150,7 +162,7 @@ func parseHTTPRequest(buf []byte, maxHeaderBytes int) (*http.Request, int, bool,
 	}
 	req.URL = parsedURL
 	req.Close = shouldCloseConnection(req, header)
-	return req, total, req.Close, nil
+	return req, headerEnd + len(headerSeparatorBuf), nil
 }
 
 func parseHTTPVersion(proto string) (int, int, error) {
@@ -236,84 +248,167 @@ func hasChunkedEncoding(hdr http.Header) (bool, error) {
 	return chunked, nil
 }
 
-func parseChunkedBody(buf []byte, start int) ([]byte, int, http.Header, error) {
-	i := start
-	if i > len(buf) {
+// parseChunkedBody decodes a complete chunked body starting at start. The
+// size limit is checked against the chunks received so far, so an oversized
+// body is rejected before its terminating chunk arrives.
+func parseChunkedBody(buf []byte, start int, maxBodyBytes int64) ([]byte, int, http.Header, error) {
+	if start > len(buf) {
 		return nil, 0, nil, errNeedMoreData
 	}
-
 	var body bytes.Buffer
-	var trailers http.Header
-
-	for {
-		lineOffset := bytes.Index(buf[i:], crlfBytes)
-		if lineOffset == -1 {
-			return nil, 0, nil, errNeedMoreData
-		}
-		lineEnd := i + lineOffset
-		sizeLine := string(buf[i:lineEnd])
-		semi := strings.Index(sizeLine, ";")
-		if semi >= 0 {
-			sizeLine = sizeLine[:semi]
+	var dec chunkedDecoder
+	tooLarge := false
+	n, err := dec.decode(buf[start:], func(p []byte) int {
+		if maxBodyBytes > 0 && int64(body.Len()+len(p)) > maxBodyBytes {
+			tooLarge = true
+			return 0
 		}
-		if sizeLine == "" {
-			return nil, 0, nil, fmt.Errorf("invalid chunk size line")
-		}
-		chunkSize, err := strconv.ParseInt(sizeLine, 16, 64)
-		if err != nil || chunkSize < 0 {
-			return nil, 0, nil, fmt.Errorf("invalid chunk size: %q", sizeLine)
-		}
-		i = lineEnd + len(crlfBytes)
+		body.Write(p)
+		return len(p)
+	})
+	if tooLarge {
+		return nil, 0, nil, errBodyTooLarge
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if !dec.done() {
+		return nil, 0, nil, errNeedMoreData
+	}
+	return body.Bytes(), start + n, dec.trailers, nil
+}
 
-		if int64(len(buf)-i) < chunkSize+int64(len(crlfBytes)) {
-			return nil, 0, nil, errNeedMoreData
-		}
+type chunkedState uint8
 
-		if chunkSize > 0 {
-			if chunkSize > math.MaxInt || i > len(buf)-int(chunkSize) {
-				return nil, 0, nil, fmt.Errorf("invalid chunk size: %q", sizeLine)
-			}
-			body.Write(buf[i : i+int(chunkSize)])
-			i += int(chunkSize)
-		}
+const (
+	chunkSizeLine chunkedState = iota
+	chunkData
+	chunkDataEnd
+	chunkTrailer
+	chunkDone
+)
 
-		if len(buf) < i+len(crlfBytes) {
-			return nil, 0, nil, errNeedMoreData
-		}
-		if !bytes.Equal(buf[i:i+len(crlfBytes)], crlfBytes) {
-			return nil, 0, nil, fmt.Errorf("invalid chunk terminator")
-		}
-		i += len(crlfBytes)
+// maxChunkLineBytes bounds a chunk-size or trailer line that has not been
+// terminated yet, so a peer cannot grow the buffer without sending a CRLF.
+const maxChunkLineBytes = 4 << 10
 
-		if chunkSize == 0 {
-			for {
-				if i >= len(buf) {
-					return nil, 0, nil, errNeedMoreData
-				}
-				lineOffset := bytes.Index(buf[i:], crlfBytes)
-				if lineOffset == -1 {
-					return nil, 0, nil, errNeedMoreData
-				}
-				if lineOffset == 0 {
-					i += len(crlfBytes)
-					break
-				}
-				line := buf[i : i+lineOffset]
-				colon := bytes.IndexByte(line, ':')
-				if colon <= 0 {
-					return nil, 0, nil, fmt.Errorf("malformed trailer line: %q", string(line))
+// chunkedDecoder incrementally decodes a chunked body. It keeps its position
+// across calls, so the input may arrive in arbitrary pieces.
+type chunkedDecoder struct {
+	state     chunkedState
+	remaining int64
+	trailers  http.Header
+}
+
+func (d *chunkedDecoder) done() bool { return d.state == chunkDone }
+
+// decode consumes as much of buf as possible and passes chunk data to emit.
+// emit returns how many bytes it accepted; accepting fewer stops decoding so
+// the caller can resume later. It returns the number of bytes consumed.
+func (d *chunkedDecoder) decode(buf []byte, emit func([]byte) int) (int, error) {
+	i := 0
+	for {
+		switch d.state {
+		case chunkSizeLine:
+			lineOffset := bytes.Index(buf[i:], crlfBytes)
+			if lineOffset == -1 {
+				if len(buf)-i > maxChunkLineBytes {
+					return i, fmt.Errorf("chunk size line too long")
 				}
-				key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(string(line[:colon])))
-				val := strings.TrimSpace(string(line[colon+1:]))
-				if trailers == nil {
-					trailers = http.Header{}
+				return i, nil
+			}
+			sizeLine := string(buf[i : i+lineOffset])
+			if semi := strings.IndexByte(sizeLine, ';'); semi >= 0 {
+				sizeLine = sizeLine[:semi]
+			}
+			sizeLine = strings.TrimSpace(sizeLine)
+			if sizeLine == "" {
+				return i, fmt.Errorf("invalid chunk size line")
+			}
+			chunkSize, err := strconv.ParseInt(sizeLine, 16, 64)
+			if err != nil || chunkSize < 0 || chunkSize > math.MaxInt {
+				return i, fmt.Errorf("invalid chunk size: %q", sizeLine)
+			}
+			i += lineOffset + len(crlfBytes)
+			if chunkSize == 0 {
+				d.state = chunkTrailer
+			} else {
+				d.remaining = chunkSize
+				d.state = chunkData
+			}
+		case chunkData:
+			n := int64(len(buf) - i)
+			if n == 0 {
+				return i, nil
+			}
+			if n > d.remaining {
+				n = d.remaining
+			}
+			accepted := emit(buf[i : i+int(n)])
+			i += accepted
+			d.remaining -= int64(accepted)
+			if int64(accepted) < n {
+				return i, nil
+			}
+			if d.remaining == 0 {
+				d.state = chunkDataEnd
+			}
+		case chunkDataEnd:
+			if len(buf)-i < len(crlfBytes) {
+				return i, nil
+			}
+			if !bytes.Equal(buf[i:i+len(crlfBytes)], crlfBytes) {
+				return i, fmt.Errorf("invalid chunk terminator")
+			}
+			i += len(crlfBytes)
+			d.state = chunkSizeLine
+		case chunkTrailer:
+			lineOffset := bytes.Index(buf[i:], crlfBytes)
+			if lineOffset == -1 {
+				if len(buf)-i > maxChunkLineBytes {
+					return i, fmt.Errorf("trailer line too long")
 				}
-				trailers.Add(key, val)
-				i += lineOffset + len(crlfBytes)
+				return i, nil
+			}
+			if lineOffset == 0 {
+				i += len(crlfBytes)
+				d.state = chunkDone
+				continue
+			}
+			line := buf[i : i+lineOffset]
+			colon := bytes.IndexByte(line, ':')
+			if colon <= 0 {
+				return i, fmt.Errorf("malformed trailer line: %q", string(line))
+			}
+			key := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(string(line[:colon])))
+			val := strings.TrimSpace(string(line[colon+1:]))
+			if d.trailers == nil {
+				d.trailers = http.Header{}
 			}
-			break
+			d.trailers.Add(key, val)
+			i += lineOffset + len(crlfBytes)
+		case chunkDone:
+			return i, nil
 		}
 	}
+}
 
-	return body.Bytes(), i, trailers, nil
+// detachRequestBody copies a body that aliases the connection buffer so the
+// request can outlive the current OnTraffic call.
+func detachRequestBody(req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	if _, ok := req.Body.(*gnetBodyReader); ok {
+		return
+	}
+	var data []byte
+	if req.ContentLength > 0 {
+		data = make([]byte, req.ContentLength)
+		n, _ := io.ReadFull(req.Body, data)
+		data = data[:n]
+	} else {
+		data, _ = io.ReadAll(req.Body)
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
 }
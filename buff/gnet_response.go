@@ -1,6 +1,9 @@
 package buff
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
 	"strconv"
 	"sync"
@@ -23,6 +26,26 @@ type gnetResponseWriter struct {
 	wroteHeader bool
 	body        *bytebufferpool.ByteBuffer
 	serverHdr   string
+
+	// streaming: once Flush has been called the head is on the wire and the
+	// remaining body is sent through send, chunk-encoded when chunked is set.
+	// err is the first failed send; later writes return it. inline is set when
+	// the handler runs on the event loop, where nothing else is read or
+	// written until it returns, so an open-ended stream cannot work.
+	pool      *bytebufferpool.Pool
+	req       *http.Request
+	reqClose  bool
+	send      func(*bytebufferpool.ByteBuffer) error
+	inline    bool
+	flushed   bool
+	chunked   bool
+	noBody    bool
+	closeConn bool
+	err       error
+
+	// conn is the connection Hijack hands out; nil when hijacking is unsupported.
+	conn     net.Conn
+	hijacked bool
 }
 
 func acquireGNetResponseWriter(pool *bytebufferpool.Pool) *gnetResponseWriter {
@@ -38,6 +61,16 @@ func acquireGNetResponseWriter(pool *bytebufferpool.Pool) *gnetResponseWriter {
 	} else {
 		w.body.Reset()
 	}
+	w.pool = pool
+	w.reqClose = false
+	w.flushed = false
+	w.chunked = false
+	w.noBody = false
+	w.closeConn = false
+	w.inline = false
+	w.err = nil
+	w.conn = nil
+	w.hijacked = false
 	return w
 }
 
@@ -48,12 +81,22 @@ func releaseGNetResponseWriter(pool *bytebufferpool.Pool, w *gnetResponseWriter)
 		w.body = nil
 	}
 	w.serverHdr = ""
+	w.pool = nil
+	w.req = nil
+	w.send = nil
+	w.conn = nil
 	gnetRespPool.Put(w)
 }
 
 func (w *gnetResponseWriter) Header() http.Header { return w.header }
 
 func (w *gnetResponseWriter) Write(b []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
 	if !w.wroteHeader {
 		w.WriteHeader(http.StatusOK)
 	}
@@ -68,12 +111,83 @@ func (w *gnetResponseWriter) WriteHeader(code int) {
 	w.wroteHeader = true
 }
 
+// Flush sends the response head and everything written so far. The first
+// Flush commits the headers and, unless the handler set Content-Length,
+// switches the response to Transfer-Encoding: chunked.
+func (w *gnetResponseWriter) Flush() { _ = w.FlushError() }
+
+// FlushError is Flush that reports a failed write, for http.ResponseController.
+func (w *gnetResponseWriter) FlushError() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.send == nil || w.req == nil || w.hijacked {
+		return nil
+	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	out := w.pool.Get()
+	out.Reset()
+	if !w.flushed {
+		w.closeConn = w.writeHead(out, true)
+		w.flushed = true
+	}
+	w.writeBody(out, w.body.Bytes())
+	w.body.Reset()
+	if out.Len() == 0 {
+		w.pool.Put(out)
+		return nil
+	}
+	w.err = w.send(out)
+	return w.err
+}
+
+// canStream reports whether flushed data reaches the client while the
+// handler is still running.
+func (w *gnetResponseWriter) canStream() bool { return w.send != nil && !w.inline }
+
+// Hijack implements http.Hijacker for WebSocket upgrades. It is available
+// for upgrade requests, which never run on the event loop, and on TLS
+// connections.
+func (w *gnetResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.conn == nil {
+		return nil, nil, http.ErrNotSupported
+	}
+	if w.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	if w.flushed {
+		return nil, nil, errors.New("buff: cannot hijack after the response was flushed")
+	}
+	w.hijacked = true
+	rw := bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn))
+	return w.conn, rw, nil
+}
+
 func (w *gnetResponseWriter) finalize(req *http.Request, reqClose bool, out *bytebufferpool.ByteBuffer) (*bytebufferpool.ByteBuffer, bool) {
+	out.Reset()
+	if w.flushed {
+		w.writeBody(out, w.body.Bytes())
+		if w.chunked && w.req.Method != http.MethodHead {
+			out.WriteString("0" + crlf + crlf)
+		}
+		return out, w.closeConn
+	}
+	w.req, w.reqClose = req, reqClose
+	shouldClose := w.writeHead(out, false)
+	w.writeBody(out, w.body.Bytes())
+	return out, shouldClose
+}
+
+// writeHead encodes the status line and headers into out and reports whether
+// the connection must be closed after the response.
+func (w *gnetResponseWriter) writeHead(out *bytebufferpool.ByteBuffer, streaming bool) bool {
 	status := w.status
 	if status == 0 {
 		status = http.StatusOK
 	}
-	body := w.body.Bytes()
+	req := w.req
 	hdr := w.header.Clone()
 
 	if _, ok := hdr["Server"]; !ok && w.serverHdr != "" {
@@ -82,14 +196,35 @@ func (w *gnetResponseWriter) finalize(req *http.Request, reqClose bool, out *byt
 	if _, ok := hdr["Date"]; !ok {
 		hdr.Set("Date", time.Now().UTC().Format(http.TimeFormat))
 	}
-	if _, ok := hdr["Content-Length"]; !ok {
-		hdr.Set("Content-Length", strconv.Itoa(len(body)))
-	}
 
 	shouldClose := connectionCloseRequested(hdr)
 	if !shouldClose {
-		shouldClose = reqClose
+		shouldClose = w.reqClose
 	}
+	_, hasLength := hdr["Content-Length"]
+	switch {
+	case status < http.StatusOK || status == http.StatusNoContent:
+		// These responses end with the header; they carry no body and
+		// neither header describing one.
+		hdr.Del("Content-Length")
+		hdr.Del("Transfer-Encoding")
+		w.noBody = true
+	case status == http.StatusNotModified:
+		// A 304 may repeat the cached representation's Content-Length but
+		// never gets one derived from its own, empty body.
+		w.noBody = true
+	case hasLength:
+	case !streaming:
+		hdr.Set("Content-Length", strconv.Itoa(w.body.Len()))
+	case req.ProtoMajor == 1 && req.ProtoMinor == 0:
+		// HTTP/1.0 has no chunked coding; the body ends when the connection does.
+		shouldClose = true
+	default:
+		hdr.Del("Transfer-Encoding")
+		hdr.Set("Transfer-Encoding", "chunked")
+		w.chunked = true
+	}
+
 	if shouldClose {
 		hdr.Set("Connection", "close")
 	} else if req.ProtoMajor == 1 && req.ProtoMinor == 0 {
@@ -98,7 +233,6 @@ func (w *gnetResponseWriter) finalize(req *http.Request, reqClose bool, out *byt
 		}
 	}
 
-	out.Reset()
 	out.WriteString("HTTP/1.1 ")
 	out.WriteString(strconv.Itoa(status))
 	out.WriteByte(' ')
@@ -106,6 +240,22 @@ func (w *gnetResponseWriter) finalize(req *http.Request, reqClose bool, out *byt
 	out.WriteString(crlf)
 	writeHeaderLines(out, hdr)
 	out.WriteString(crlf)
-	out.Write(body)
-	return out, shouldClose
+	return shouldClose
+}
+
+// writeBody appends p to out, chunk-encoded if needed. Responses to HEAD keep
+// the headers, including the Content-Length of the body, but drop the body,
+// as do 1xx, 204 and 304 responses.
+func (w *gnetResponseWriter) writeBody(out *bytebufferpool.ByteBuffer, p []byte) {
+	if len(p) == 0 || w.noBody || w.req.Method == http.MethodHead {
+		return
+	}
+	if !w.chunked {
+		out.Write(p)
+		return
+	}
+	out.WriteString(strconv.FormatInt(int64(len(p)), 16))
+	out.WriteString(crlf)
+	out.Write(p)
+	out.WriteString(crlf)
 }
@@ -13,10 +13,14 @@ func (e *Engine) RunGNet(addr string, opts ...GNetRunOption) error {
 		return fmt.Errorf("missing address")
 	}
 	cfg := defaultGNetRunConfig()
+	cfg.maxBodyBytes = e.maxBodyBytes
 	for _, opt := range opts {
 		opt(&cfg)
 	}
-	handler := newGNetHTTPHandler(e.R, cfg)
+	handler, err := newGNetHTTPHandler(e.R, cfg)
+	if err != nil {
+		return err
+	}
 	protoAddr := ensureProtoAddr(addr)
 	log.Printf("buff gnet listening on %s", addr)
 	return gnet.Run(handler, protoAddr, cfg.opts...)
@@ -1,17 +1,32 @@
 package buff
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	gnet "github.com/panjf2000/gnet/v2"
 	"github.com/valyala/bytebufferpool"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
 )
 
 func TestParseHTTPRequestBasic(t *testing.T) {
 	raw := "GET /hello HTTP/1.1\r\nHost: example.com\r\nUser-Agent: test\r\n\r\n"
-	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096)
+	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -31,7 +46,7 @@ func TestParseHTTPRequestBasic(t *testing.T) {
 
 func TestParseHTTPRequestContentLength(t *testing.T) {
 	raw := "POST /data HTTP/1.1\r\nHost: example.com\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhelloextra"
-	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096)
+	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -92,7 +107,7 @@ func TestParseHTTPRequestChunked(t *testing.T) {
 		"5\r\npedia\r\n" +
 		"0\r\n" +
 		"\r\n"
-	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096)
+	req, consumed, closeAfter, err := parseHTTPRequest([]byte(raw), 4096, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -126,10 +141,9 @@ func TestParseHTTPRequestChunkedWithTrailer(t *testing.T) {
 		"\r\n" +
 		"4\r\nWiki\r\n" +
 		"0\r\n" +
-		"\r\n" +
 		"X-Custom: value\r\n" +
 		"\r\n"
-	req, consumed, _, err := parseHTTPRequest([]byte(raw), 4096)
+	req, consumed, _, err := parseHTTPRequest([]byte(raw), 4096, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -140,3 +154,731 @@ func TestParseHTTPRequestChunkedWithTrailer(t *testing.T) {
 		t.Fatalf("expected trailer promoted into header, got %q", req.Header.Get("X-Custom"))
 	}
 }
+
+func startGNetTestServer(t *testing.T, e *Engine, opts ...GNetRunOption) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("gnet is not supported on Windows")
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	opts = append([]GNetRunOption{WithGNetShutdownSignals(syscall.SIGUSR2)}, opts...)
+	errCh := make(chan error, 1)
+	go func() { errCh <- e.RunGNet(addr, opts...) }()
+	waitForServer(t, "http://"+addr+"/ping")
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := gnet.Stop(ctx, ensureProtoAddr(addr)); err != nil {
+			t.Errorf("stop gnet server: %v", err)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("gnet server error: %v", err)
+		}
+	})
+	return addr
+}
+
+func TestGNetWorkerPoolDoesNotBlockEventLoop(t *testing.T) {
+	e := newBenchmarkEngine()
+	release := make(chan struct{})
+	e.GET("/slow", func(c *Context) {
+		<-release
+		_ = c.Text(http.StatusOK, "slow")
+	})
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(4, 16))
+
+	slowDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		slowDone <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/ping")
+	if err != nil {
+		t.Fatalf("fast request blocked by slow handler: %v", err)
+	}
+	_ = resp.Body.Close()
+	close(release)
+	if err := <-slowDone; err != nil {
+		t.Fatalf("slow request: %v", err)
+	}
+}
+
+func TestGNetWorkerPoolPipelinedOrder(t *testing.T) {
+	e := newBenchmarkEngine()
+	e.GET("/sleep/:ms", func(c *Context) {
+		ms, _ := strconv.Atoi(c.Param("ms"))
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		_ = c.Text(http.StatusOK, c.Param("ms"))
+	})
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(4, 16))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	want := []string{"80", "0", "40"}
+	var raw strings.Builder
+	for _, ms := range want {
+		fmt.Fprintf(&raw, "GET /sleep/%s HTTP/1.1\r\nHost: test\r\n\r\n", ms)
+	}
+	if _, err := io.WriteString(conn, raw.String()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	for _, w := range want {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != w {
+			t.Fatalf("expected response %q, got %q", w, string(body))
+		}
+	}
+}
+
+func TestChunkedDecoderIncremental(t *testing.T) {
+	raw := "4\r\nWiki\r\n5;ext=1\r\npedia\r\n0\r\nX-Sum: 9\r\n\r\nNEXT"
+	var dec chunkedDecoder
+	var body []byte
+	emit := func(p []byte) int {
+		body = append(body, p...)
+		return len(p)
+	}
+	pending := ""
+	consumed := 0
+	for i := 0; i < len(raw) && !dec.done(); i++ {
+		pending += raw[i : i+1]
+		n, err := dec.decode([]byte(pending), emit)
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		consumed += n
+		pending = pending[n:]
+	}
+	if !dec.done() {
+		t.Fatalf("decoder did not finish")
+	}
+	if string(body) != "Wikipedia" {
+		t.Fatalf("unexpected body %q", string(body))
+	}
+	if raw[consumed:] != "NEXT" {
+		t.Fatalf("decoder consumed past the body: %q left", raw[consumed:])
+	}
+	if dec.trailers.Get("X-Sum") != "9" {
+		t.Fatalf("expected trailer, got %#v", dec.trailers)
+	}
+}
+
+func TestGNetStreamRequestBody(t *testing.T) {
+	e := newBenchmarkEngine()
+	started := make(chan struct{})
+	e.POST("/upload", func(c *Context) {
+		close(started)
+		n, err := io.Copy(io.Discard, c.Request.Body)
+		if err != nil {
+			_ = c.Text(http.StatusBadRequest, err.Error())
+			return
+		}
+		_ = c.Text(http.StatusOK, fmt.Sprintf("%d %s", n, c.Request.Trailer.Get("X-Sum")))
+	})
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(4, 16), WithGNetStreamRequestBody(16))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	head := "POST /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n"
+	if _, err := io.WriteString(conn, head+"40\r\n"+strings.Repeat("a", 32)); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler was not dispatched before the body completed")
+	}
+	rest := strings.Repeat("b", 32) + "\r\n" + "0\r\nX-Sum: 64\r\n\r\n"
+	if _, err := io.WriteString(conn, rest+"GET /ping HTTP/1.1\r\nHost: test\r\n\r\n"); err != nil {
+		t.Fatalf("write body: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	for _, want := range []string{"64 64", "pong"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != want {
+			t.Fatalf("expected %q, got %q", want, string(body))
+		}
+	}
+}
+
+func TestGNetPipelineQueueIsBounded(t *testing.T) {
+	const requests = 4 * gnetMaxPendingRequests
+	gate := make(chan struct{})
+	e := newBenchmarkEngine()
+	e.GET("/slow/:n", func(c *Context) {
+		<-gate
+		_ = c.Text(http.StatusOK, c.Param("n"))
+	})
+	e.GET("/stuck", func(c *Context) { <-c.Request.Context().Done() })
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(2, 2))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	var raw strings.Builder
+	for i := 0; i < requests; i++ {
+		fmt.Fprintf(&raw, "GET /slow/%d HTTP/1.1\r\nHost: t\r\n\r\n", i)
+	}
+	if _, err := io.WriteString(conn, raw.String()); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(gate)
+	// Parsing resumes as the queue drains, so every response still arrives in order.
+	br := bufio.NewReader(conn)
+	for i := 0; i < requests; i++ {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("response %d: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != strconv.Itoa(i) {
+			t.Fatalf("response %d: got %q", i, body)
+		}
+	}
+
+	// A client that keeps pipelining behind a handler that never finishes is
+	// cut off instead of having every request parsed and queued.
+	stuck, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer stuck.Close()
+	_ = stuck.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	go func() { _, _ = io.Copy(io.Discard, stuck) }()
+	batch := []byte(strings.Repeat("GET /stuck HTTP/1.1\r\nHost: t\r\n\r\n", 1024))
+	sent := 0
+	for sent < 200<<20 {
+		n, err := stuck.Write(batch)
+		sent += n
+		if err != nil {
+			break
+		}
+	}
+	if sent > 32<<20 {
+		t.Fatalf("server accepted %d bytes of pipelined requests behind a stuck handler", sent)
+	}
+}
+
+// TestGNetStalledReaderBoundsMemory pushes far more data than the buffers
+// allow at handlers that never read it. gnet keeps reading the socket, so the
+// server must cut the client off rather than buffer the whole upload.
+func TestGNetStalledReaderBoundsMemory(t *testing.T) {
+	const total, bound = 200 << 20, 32 << 20
+	e := newBenchmarkEngine()
+	e.POST("/upload", func(c *Context) { <-c.Request.Context().Done() })
+	e.WS("/ws", func(c *Context, ws *WSConn) { <-c.Request.Context().Done() })
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(2, 2), WithGNetStreamRequestBody(64<<10))
+
+	for name, head := range map[string]string{
+		"body": fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: t\r\nContent-Length: %d\r\n\r\n", total),
+		"tunnel": "GET /ws HTTP/1.1\r\nHost: t\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if _, err := io.WriteString(conn, head); err != nil {
+				t.Fatalf("write head: %v", err)
+			}
+			go func() { _, _ = io.Copy(io.Discard, conn) }()
+
+			chunk := make([]byte, 64<<10)
+			sent := 0
+			for sent < total {
+				n, err := conn.Write(chunk)
+				sent += n
+				if err != nil {
+					break
+				}
+			}
+			if sent > bound {
+				t.Fatalf("server accepted %d bytes from a stalled client, want at most %d", sent, bound)
+			}
+		})
+	}
+}
+
+func TestParseHTTPRequestBodyTooLarge(t *testing.T) {
+	raw := "POST /data HTTP/1.1\r\nHost: example.com\r\nContent-Length: 1024\r\n\r\nabc"
+	if _, _, _, err := parseHTTPRequest([]byte(raw), 4096, 16); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge before the body arrives, got %v", err)
+	}
+
+	chunked := "POST /data HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"8\r\n12345678\r\n" +
+		"8\r\n12345678\r\n" +
+		"8\r\n1234"
+	if _, _, _, err := parseHTTPRequest([]byte(chunked), 4096, 12); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected errBodyTooLarge for oversized chunks, got %v", err)
+	}
+	if _, _, _, err := parseHTTPRequest([]byte(chunked), 4096, 64); !errors.Is(err, errNeedMoreData) {
+		t.Fatalf("expected errNeedMoreData under the limit, got %v", err)
+	}
+}
+
+func TestGNetResponseWriterFlushChunked(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/stream", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	pool := &bytebufferpool.Pool{}
+	var wire strings.Builder
+	w := acquireGNetResponseWriter(pool)
+	w.req = req
+	w.send = func(buf *bytebufferpool.ByteBuffer) error {
+		wire.Write(buf.Bytes())
+		pool.Put(buf)
+		return nil
+	}
+
+	_, _ = w.Write([]byte("hello "))
+	w.Flush()
+	if !strings.Contains(wire.String(), "Transfer-Encoding: chunked") {
+		t.Fatalf("expected chunked head after first flush, got: %s", wire.String())
+	}
+	if strings.Contains(wire.String(), "Content-Length") {
+		t.Fatalf("streamed response must not carry Content-Length: %s", wire.String())
+	}
+	_, _ = w.Write([]byte("world"))
+
+	respBuf := pool.Get()
+	respBuf, closeAfter := w.finalize(req, false, respBuf)
+	if closeAfter {
+		t.Fatalf("expected connection kept alive")
+	}
+	wire.Write(respBuf.Bytes())
+	pool.Put(respBuf)
+	releaseGNetResponseWriter(pool, w)
+
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(wire.String())), req)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "hello world" {
+		t.Fatalf("unexpected body %q err=%v", string(body), err)
+	}
+}
+
+func TestGNetResponseWriterFlushError(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/stream", nil)
+	pool := &bytebufferpool.Pool{}
+	w := acquireGNetResponseWriter(pool)
+	w.req = req
+	w.send = func(buf *bytebufferpool.ByteBuffer) error {
+		pool.Put(buf)
+		return net.ErrClosed
+	}
+
+	_, _ = w.Write([]byte("event"))
+	if err := http.NewResponseController(w).Flush(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected the send error from Flush, got %v", err)
+	}
+	if _, err := w.Write([]byte("more")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected writes after a failed flush to fail, got %v", err)
+	}
+}
+
+func TestGNetStreamingResponse(t *testing.T) {
+	e := newBenchmarkEngine()
+	release := make(chan struct{})
+	e.GET("/stream", func(c *Context) {
+		_ = c.Text(http.StatusOK, "first\n")
+		c.Writer.(http.Flusher).Flush()
+		<-release
+		_, _ = io.WriteString(c.Writer, "second\n")
+	})
+	addr := startGNetTestServer(t, e, WithGNetWorkerPool(4, 16))
+
+	client := &http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get("http://" + addr + "/stream")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Fatalf("expected chunked response, got %#v", resp.TransferEncoding)
+	}
+	br := bufio.NewReader(resp.Body)
+	line, err := br.ReadString('\n')
+	if err != nil || line != "first\n" {
+		t.Fatalf("expected first line before handler finished, got %q err=%v", line, err)
+	}
+	close(release)
+	rest, err := io.ReadAll(br)
+	if err != nil || string(rest) != "second\n" {
+		t.Fatalf("unexpected tail %q err=%v", string(rest), err)
+	}
+}
+
+func h2cTestClient() *http.Client {
+	return &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func TestGNetH2CPriorKnowledge(t *testing.T) {
+	for name, opts := range map[string][]GNetRunOption{
+		"inline":  {WithGNetH2C()},
+		"workers": {WithGNetH2C(), WithGNetWorkerPool(4, 16)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := newBenchmarkEngine()
+			e.POST("/echo", func(c *Context) {
+				b, _ := io.ReadAll(c.Request.Body)
+				_ = c.Text(http.StatusOK, fmt.Sprintf("%s %s", c.Request.Proto, b))
+			})
+			addr := startGNetTestServer(t, e, opts...)
+			client := h2cTestClient()
+			defer client.CloseIdleConnections()
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					body := strings.Repeat(strconv.Itoa(i), 100<<10)
+					resp, err := client.Post("http://"+addr+"/echo", "text/plain", strings.NewReader(body))
+					if err != nil {
+						errs <- err
+						return
+					}
+					defer resp.Body.Close()
+					got, _ := io.ReadAll(resp.Body)
+					if resp.Header.Get("Server") != defaultServerHeader {
+						errs <- fmt.Errorf("missing server header: %v", resp.Header)
+					}
+					if string(got) != "HTTP/2.0 "+body {
+						errs <- fmt.Errorf("stream %d: unexpected body prefix %.20q", i, got)
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestGNetH2CUpgrade(t *testing.T) {
+	e := newBenchmarkEngine()
+	addr := startGNetTestServer(t, e, WithGNetH2C())
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	req := "GET /ping HTTP/1.1\r\nHost: test\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read 101: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+
+	if _, err := conn.Write(h2Preface); err != nil {
+		t.Fatalf("write preface: %v", err)
+	}
+	fr := http2.NewFramer(conn, br)
+	if err := fr.WriteSettings(); err != nil {
+		t.Fatalf("write settings: %v", err)
+	}
+	dec := hpack.NewDecoder(4096, nil)
+	var status, body string
+	for done := false; !done; {
+		f, err := fr.ReadFrame()
+		if err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		switch f := f.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				_ = fr.WriteSettingsAck()
+			}
+		case *http2.HeadersFrame:
+			if f.StreamID != 1 {
+				t.Fatalf("expected stream 1, got %d", f.StreamID)
+			}
+			fields, err := dec.DecodeFull(f.HeaderBlockFragment())
+			if err != nil {
+				t.Fatalf("decode headers: %v", err)
+			}
+			for _, hf := range fields {
+				if hf.Name == ":status" {
+					status = hf.Value
+				}
+			}
+			done = f.StreamEnded()
+		case *http2.DataFrame:
+			body += string(f.Data())
+			done = f.StreamEnded()
+		}
+	}
+	if status != "200" || body != "pong" {
+		t.Fatalf("unexpected upgraded response %s %q", status, body)
+	}
+}
+
+func TestGNetH2CDisabledRejectsPreface(t *testing.T) {
+	e := newBenchmarkEngine()
+	addr := startGNetTestServer(t, e)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(h2Preface); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestGNetBodylessStatusHeaders(t *testing.T) {
+	for name, opts := range map[string][]GNetRunOption{
+		"inline":  nil,
+		"workers": {WithGNetWorkerPool(4, 16)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := newBenchmarkEngine()
+			e.GET("/empty", func(c *Context) {
+				c.Writer.WriteHeader(http.StatusNoContent)
+				_, _ = io.WriteString(c.Writer, "dropped")
+				c.Writer.(http.Flusher).Flush()
+			})
+			e.GET("/cached", func(c *Context) { c.Writer.WriteHeader(http.StatusNotModified) })
+			addr := startGNetTestServer(t, e, opts...)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			raw := "OPTIONS /ping HTTP/1.1\r\nHost: t\r\n\r\n" +
+				"GET /empty HTTP/1.1\r\nHost: t\r\n\r\n" +
+				"GET /cached HTTP/1.1\r\nHost: t\r\n\r\n" +
+				"GET /ping HTTP/1.1\r\nHost: t\r\n\r\n"
+			if _, err := io.WriteString(conn, raw); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			br := bufio.NewReader(conn)
+			for _, want := range []string{"204", "204", "304"} {
+				status, err := br.ReadString('\n')
+				if err != nil || !strings.HasPrefix(status, "HTTP/1.1 "+want) {
+					t.Fatalf("expected %s, got %q err=%v", want, status, err)
+				}
+				for {
+					line, err := br.ReadString('\n')
+					if err != nil {
+						t.Fatalf("read header: %v", err)
+					}
+					if line == "\r\n" {
+						break
+					}
+					if k, _, _ := strings.Cut(line, ":"); k == "Content-Length" || k == "Transfer-Encoding" {
+						t.Fatalf("unexpected %q in %s response", strings.TrimSpace(line), want)
+					}
+				}
+			}
+			// A body leaking from any of them would corrupt this response.
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("read response: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "pong" {
+				t.Fatalf("unexpected body %q", body)
+			}
+		})
+	}
+}
+
+func TestGNetHeadSuppressesBody(t *testing.T) {
+	for name, opts := range map[string][]GNetRunOption{
+		"inline":  nil,
+		"workers": {WithGNetWorkerPool(4, 16)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			e := newBenchmarkEngine()
+			e.GET("/stream", func(c *Context) {
+				_, _ = io.WriteString(c.Writer, "part1")
+				c.Writer.(http.Flusher).Flush()
+				_, _ = io.WriteString(c.Writer, "part2")
+			})
+			addr := startGNetTestServer(t, e, opts...)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatalf("dial: %v", err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+			// A body leaking from either HEAD response would corrupt the GET that follows.
+			raw := "HEAD /ping HTTP/1.1\r\nHost: t\r\n\r\nHEAD /stream HTTP/1.1\r\nHost: t\r\n\r\nGET /ping HTTP/1.1\r\nHost: t\r\n\r\n"
+			if _, err := conn.Write([]byte(raw)); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			br := bufio.NewReader(conn)
+			head := &http.Request{Method: http.MethodHead}
+			resp, err := http.ReadResponse(br, head)
+			if err != nil {
+				t.Fatalf("read HEAD /ping: %v", err)
+			}
+			if resp.StatusCode != http.StatusOK || resp.ContentLength != 4 {
+				t.Fatalf("HEAD /ping: status %d, content length %d", resp.StatusCode, resp.ContentLength)
+			}
+			if resp, err = http.ReadResponse(br, head); err != nil || resp.StatusCode != http.StatusOK {
+				t.Fatalf("HEAD /stream: %v %v", resp, err)
+			}
+			resp, err = http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("read GET: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != "pong" {
+				t.Fatalf("GET after HEAD: unexpected body %q", body)
+			}
+		})
+	}
+}
+
+func TestGNetHostRouting(t *testing.T) {
+	e := newBenchmarkEngine()
+	e.Host("{tenant}.example.com").GET("/ping", func(c *Context) {
+		_ = c.Text(http.StatusOK, "pong "+c.Param("tenant"))
+	})
+	addr := startGNetTestServer(t, e)
+
+	for host, want := range map[string]string{"acme.example.com:8080": "pong acme", "localhost": "pong"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+addr+"/ping", nil)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Fatalf("Host %s: got %q, want %q", host, body, want)
+		}
+	}
+}
+
+func TestGNetMountFileServer(t *testing.T) {
+	e := newBenchmarkEngine()
+	files := fstest.MapFS{"docs/readme.txt": {Data: []byte("hello")}}
+	if err := e.Mount("/static", http.FileServer(http.FS(files))); err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+	addr := startGNetTestServer(t, e)
+
+	resp, err := http.Get("http://" + addr + "/static/docs/readme.txt")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Fatalf("file: %d %q", resp.StatusCode, body)
+	}
+
+	// Directory listings need the trailing slash to survive the mount.
+	resp, err = http.Get("http://" + addr + "/static/docs/")
+	if err != nil {
+		t.Fatalf("get dir: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "readme.txt") {
+		t.Fatalf("dir: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestGNetProblemDetailsForParseErrors(t *testing.T) {
+	e := newBenchmarkEngine()
+	e.SetProblemDetails(true)
+	addr := startGNetTestServer(t, e, WithGNetMaxHeaderBytes(256))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	raw := "GET /ping HTTP/1.1\r\nHost: t\r\nX-Big: " + strings.Repeat("a", 512) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(raw)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `"status":431`) || !strings.Contains(string(body), `"type":"about:blank"`) {
+		t.Fatalf("unexpected body %s", body)
+	}
+}
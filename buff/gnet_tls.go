@@ -0,0 +1,191 @@
+package buff
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/valyala/bytebufferpool"
+)
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// tlsTunnelBuffer bounds the ciphertext waiting for serveTLS, in the tunnel's
+// reader and again as backlog once it is full. It fits several full records
+// (16 KiB of plaintext plus overhead each) and does not depend on
+// WithGNetStreamRequestBody, whose bound is for plaintext bodies.
+const tlsTunnelBuffer = 64 << 10
+
+// serveTLS runs the TLS record layer over raw and serves HTTP/1.1 requests
+// read from it with the same parser and response writer as plaintext
+// connections. With a worker pool the handlers run on it, and a streamed
+// body is fed to the handler while it runs, as on plaintext connections.
+func (h *gnetHTTPHandler) serveTLS(raw *gnetTunnelConn, connCtx context.Context) {
+	tc := tls.Server(raw, h.tlsConfig)
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			_ = tc.Close()
+		}
+	}()
+
+	hsCtx, cancel := context.WithTimeout(connCtx, tlsHandshakeTimeout)
+	err := tc.HandshakeContext(hsCtx)
+	cancel()
+	if err != nil {
+		return
+	}
+	state := tc.ConnectionState()
+
+	send := func(buf *bytebufferpool.ByteBuffer) error {
+		_, err := tc.Write(buf.Bytes())
+		h.bufPool.Put(buf)
+		return err
+	}
+	var buf []byte
+	chunk := make([]byte, 16<<10)
+	read := func() error {
+		n, err := tc.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		return err
+	}
+	wake := make(chan struct{}, 1)
+	for {
+		req, consumed, closeAfter, err := parseHTTPRequest(buf, h.maxHeaderBytes, h.maxBodyBytes)
+		var feed *gnetBodyFeed
+		if errors.Is(err, errNeedMoreData) && h.streamBodyBuffer > 0 {
+			req, consumed, feed, err = h.beginBodyStream(buf, func() {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			})
+			if err == nil {
+				closeAfter = req.Close
+			}
+		}
+		if err != nil {
+			if errors.Is(err, errNeedMoreData) {
+				if read() != nil {
+					return
+				}
+				continue
+			}
+			switch {
+			case errors.Is(err, errHeaderTooLarge):
+				h.writeError(tc, http.StatusRequestHeaderFieldsTooLarge, err.Error())
+			case errors.Is(err, errBodyTooLarge):
+				h.writeError(tc, http.StatusRequestEntityTooLarge, err.Error())
+			default:
+				h.writeError(tc, http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+
+		req = req.WithContext(connCtx)
+		req.RemoteAddr = raw.RemoteAddr().String()
+		req.TLS = &state
+		detachRequestBody(req)
+		buf = buf[:copy(buf, buf[consumed:])]
+
+		var conn net.Conn = tc
+		if feed == nil && isUpgradeRequest(req.Header) {
+			// Let a WebSocket handler take over; bytes already read are handed
+			// over through the hijacked reader.
+			conn = &bufferedConn{Conn: tc, pending: buf}
+		}
+		res := make(chan tlsResult, 1)
+		run := func() {
+			respBuf, shouldClose, hj := h.serve(req, closeAfter, send, conn, false)
+			r := tlsResult{shouldClose: shouldClose, hijacked: hj}
+			if !hj {
+				_, r.err = tc.Write(respBuf.Bytes())
+			}
+			h.bufPool.Put(respBuf)
+			res <- r
+		}
+		if h.workers == nil || conn != tc {
+			// An upgrade may keep the connection for as long as its handler
+			// lives; it runs here instead of holding a worker.
+			run()
+		} else if err := h.workers.submit(run); err != nil {
+			h.writeError(tc, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		if feed != nil && !h.feedTLSBody(feed, &buf, read, wake) {
+			<-res
+			return
+		}
+		r := <-res
+		if r.hijacked {
+			hijacked = true
+			return
+		}
+		if r.err != nil || r.shouldClose {
+			return
+		}
+	}
+}
+
+// tlsResult is the outcome of a request served on a TLS connection.
+type tlsResult struct {
+	shouldClose bool
+	hijacked    bool
+	err         error
+}
+
+// feedTLSBody hands the body of the running request to its reader, reading
+// more from the connection as needed and waiting while the reader is full.
+// It reports false when the body could not be read to the end, in which case
+// the connection must be closed once the handler returns.
+func (h *gnetHTTPHandler) feedTLSBody(feed *gnetBodyFeed, buf *[]byte, read func() error, wake <-chan struct{}) bool {
+	for {
+		select {
+		case <-wake:
+		default:
+		}
+		n, done, err := feed.feed(*buf)
+		*buf = (*buf)[:copy(*buf, (*buf)[n:])]
+		switch {
+		case err != nil:
+			feed.r.fail(err)
+			return false
+		case done:
+			return true
+		case feed.r.isStalled():
+			// The handler reading or closing the body, which it does when it
+			// returns, ends the wait.
+			<-wake
+			continue
+		}
+		select {
+		case <-wake:
+			// The reader made room while it was being fed.
+		default:
+			if err := read(); err != nil {
+				feed.r.fail(io.ErrUnexpectedEOF)
+				return false
+			}
+		}
+	}
+}
+
+// bufferedConn replays bytes read past the end of an upgrade request before
+// reading from the underlying connection.
+type bufferedConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	if len(b.pending) > 0 {
+		n := copy(p, b.pending)
+		b.pending = b.pending[n:]
+		return n, nil
+	}
+	return b.Conn.Read(p)
+}
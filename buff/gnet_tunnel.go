@@ -0,0 +1,68 @@
+package buff
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	gnet "github.com/panjf2000/gnet/v2"
+)
+
+// gnetTunnelConn is the net.Conn handed out by Hijack on the gnet engine.
+// Once a connection is tunneled the event loop stops parsing HTTP and feeds
+// every inbound byte to r; writes go out through AsyncWrite.
+type gnetTunnelConn struct {
+	r      *gnetBodyReader
+	c      gnet.Conn
+	local  net.Addr
+	remote net.Addr
+	closed atomic.Bool
+}
+
+// newGNetTunnelConn must be called on the event loop.
+func newGNetTunnelConn(c gnet.Conn, bufSize int) *gnetTunnelConn {
+	return &gnetTunnelConn{
+		r:      newGNetBodyReader(bufSize, nil, func() { _ = c.Wake(nil) }),
+		c:      c,
+		local:  c.LocalAddr(),
+		remote: c.RemoteAddr(),
+	}
+}
+
+func (t *gnetTunnelConn) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if errors.Is(err, errBodyReadAfterClose) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (t *gnetTunnelConn) Write(p []byte) (int, error) {
+	if t.closed.Load() {
+		return 0, net.ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	buf := append([]byte(nil), p...)
+	if err := t.c.AsyncWrite(buf, nil); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *gnetTunnelConn) Close() error {
+	if !t.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	_ = t.r.Close()
+	return t.c.Close()
+}
+
+func (t *gnetTunnelConn) LocalAddr() net.Addr  { return t.local }
+func (t *gnetTunnelConn) RemoteAddr() net.Addr { return t.remote }
+
+func (t *gnetTunnelConn) SetDeadline(time.Time) error      { return errors.ErrUnsupported }
+func (t *gnetTunnelConn) SetReadDeadline(time.Time) error  { return errors.ErrUnsupported }
+func (t *gnetTunnelConn) SetWriteDeadline(time.Time) error { return errors.ErrUnsupported }
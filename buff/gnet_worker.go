@@ -0,0 +1,71 @@
+package buff
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/panjf2000/ants/v2"
+)
+
+var errWorkerPoolBusy = errors.New("worker pool queue is full")
+
+// gnetWorkerPool runs handlers off the event loop. Tasks are queued into a
+// bounded channel and fed to an ants pool by a single dispatcher, so the
+// event loop never blocks on Submit.
+type gnetWorkerPool struct {
+	pool  *ants.Pool
+	queue chan func()
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newGNetWorkerPool(size, queue int) (*gnetWorkerPool, error) {
+	p, err := ants.NewPool(size, ants.WithMaxBlockingTasks(1))
+	if err != nil {
+		return nil, err
+	}
+	wp := &gnetWorkerPool{
+		pool:  p,
+		queue: make(chan func(), queue),
+		done:  make(chan struct{}),
+	}
+	go wp.dispatch()
+	return wp, nil
+}
+
+func (wp *gnetWorkerPool) dispatch() {
+	for {
+		select {
+		case task := <-wp.queue:
+			if err := wp.pool.Submit(task); err != nil {
+				task()
+			}
+		case <-wp.done:
+			return
+		}
+	}
+}
+
+func (wp *gnetWorkerPool) submit(task func()) error {
+	select {
+	case <-wp.done:
+		return ants.ErrPoolClosed
+	default:
+	}
+	select {
+	case wp.queue <- task:
+		return nil
+	default:
+		return errWorkerPoolBusy
+	}
+}
+
+func (wp *gnetWorkerPool) release(timeout time.Duration) error {
+	var err error
+	wp.once.Do(func() {
+		close(wp.done)
+		err = wp.pool.ReleaseTimeout(timeout)
+	})
+	return err
+}
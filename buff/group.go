@@ -0,0 +1,160 @@
+package buff
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// anyMethods are the methods registered by Any.
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+	http.MethodHead, http.MethodOptions, http.MethodConnect, http.MethodTrace,
+}
+
+// Group registers routes below a common prefix with shared middleware.
+// Groups nest; a group's middleware runs after its parent's and, like global
+// middleware, applies to its routes whenever it was added.
+type Group struct {
+	r      *Router
+	parent *Group
+	base   string
+	host   string // host pattern, "" for any host
+	mw     []Middleware
+}
+
+// Group starts a route group below prefix with middleware m.
+func (r *Router) Group(prefix string, m ...Middleware) *Group {
+	return &Group{r: r, base: joinPath("", prefix), mw: m}
+}
+
+// Group returns a sub-group whose prefix is appended to g's.
+func (g *Group) Group(prefix string, m ...Middleware) *Group {
+	return &Group{r: g.r, parent: g, base: joinPath(g.base, prefix), host: g.host, mw: m}
+}
+
+// Host starts a route group served only for requests whose Host header
+// matches pattern, ignoring case and port. Labels may be params, as in
+// "{tenant}.example.com", captured like path params. A matching host is
+// routed with its own routes only; other hosts use the routes registered
+// without one. An invalid pattern is reported when a route is added.
+func (r *Router) Host(pattern string, m ...Middleware) *Group {
+	return &Group{r: r, host: pattern, mw: m}
+}
+
+// Use appends middleware to the group.
+func (g *Group) Use(m ...Middleware) {
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	g.mw = append(g.mw, m...)
+	g.r.markStale()
+}
+
+// middleware returns the stack of g and its parents, outermost first. The
+// router's mu must be held.
+func (g *Group) middleware() []Middleware {
+	if g == nil {
+		return nil
+	}
+	return append(g.parent.middleware(), g.mw...)
+}
+
+func (g *Group) Handle(method, path string, h Handler, mws ...Middleware) error {
+	return g.HandleNamed("", method, path, h, mws...)
+}
+
+// HandleNamed registers a named route below the group prefix.
+func (g *Group) HandleNamed(name, method, path string, h Handler, mws ...Middleware) error {
+	full := joinPath(g.base, path)
+	if full == "" {
+		full = "/"
+	}
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	return g.r.addLocked(name, strings.ToUpper(method), normalize(full), g, h, mws, false)
+}
+
+// Remove unregisters the route for method and path, spelled as passed to
+// the group when it was added, from the group's host.
+func (g *Group) Remove(method, path string) error {
+	full := joinPath(g.base, path)
+	if full == "" {
+		full = "/"
+	}
+	host := ""
+	if g.host != "" {
+		var err error
+		if host, _, err = parseHostPattern(g.host); err != nil {
+			return err
+		}
+	}
+	g.r.mu.Lock()
+	defer g.r.mu.Unlock()
+	return g.r.removeLocked(host, strings.ToUpper(method), normalize(full))
+}
+
+func (g *Group) GET(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodGet, path, h, mws...)
+}
+func (g *Group) POST(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodPost, path, h, mws...)
+}
+func (g *Group) PUT(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodPut, path, h, mws...)
+}
+func (g *Group) PATCH(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodPatch, path, h, mws...)
+}
+func (g *Group) DELETE(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodDelete, path, h, mws...)
+}
+func (g *Group) HEAD(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodHead, path, h, mws...)
+}
+func (g *Group) OPTIONS(path string, h Handler, mws ...Middleware) error {
+	return g.Handle(http.MethodOptions, path, h, mws...)
+}
+
+// Any registers h for every standard method.
+func (g *Group) Any(path string, h Handler, mws ...Middleware) error {
+	var errs []error
+	for _, m := range anyMethods {
+		errs = append(errs, g.Handle(m, path, h, mws...))
+	}
+	return errors.Join(errs...)
+}
+
+// Mount serves h for prefix and every path below it, for all methods. h sees
+// the request path with prefix stripped, "/" for prefix itself, so a
+// sub-router, http.FileServer or http.ServeMux works unchanged.
+func (g *Group) Mount(prefix string, h http.Handler, mws ...Middleware) error {
+	sub, mh := g.Group(prefix), mountHandler(h)
+	return errors.Join(sub.Any("/", mh, mws...), sub.Any("/*", mh, mws...))
+}
+
+// mountHandler passes the part of the path matched by the mount's splat on
+// to h, keeping a trailing slash so file servers can tell directories apart.
+func mountHandler(h http.Handler) Handler {
+	return func(c *Context) {
+		rest := "/" + c.Param("")
+		if rest != "/" && strings.HasSuffix(c.Request.URL.Path, "/") {
+			rest += "/"
+		}
+		req := new(http.Request)
+		*req = *c.Request
+		req.URL = new(url.URL)
+		*req.URL = *c.Request.URL
+		req.URL.Path, req.URL.RawPath = rest, ""
+		h.ServeHTTP(c.Writer, req)
+	}
+}
+
+// joinPath appends path to base as a new segment; an empty or "/" path
+// yields base itself.
+func joinPath(base, path string) string {
+	if p := strings.Trim(path, "/"); p != "" {
+		return base + "/" + p
+	}
+	return base
+}
@@ -1,3 +1,11 @@
 package buff
 
+import "net/http"
+
 type Handler func(btx *Context)
+
+// Wrap adapts a net/http handler. It writes through c.Writer, so Logger and
+// other middleware still see the status and body size.
+func Wrap(h http.Handler) Handler {
+	return func(c *Context) { h.ServeHTTP(c.Writer, c.Request) }
+}
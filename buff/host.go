@@ -0,0 +1,155 @@
+package buff
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hostTree is the route tree selected by a Host pattern such as
+// "api.example.com" or "{tenant}.example.com".
+type hostTree struct {
+	pattern string // canonical form: literal labels lower-cased, no trailing dot
+	labels  []hostLabel
+	routeTree
+}
+
+// hostLabel is one dot-separated label of a host pattern: either a literal or
+// a param with an optional constraint.
+type hostLabel struct {
+	lit  string
+	name string
+	cons *paramConstraint
+}
+
+// parseHostPattern splits pattern into labels. Param labels use the path
+// syntax, :name, {name} or {name:constraint}; a constraint cannot contain a
+// dot since labels are split first.
+func parseHostPattern(pattern string) (string, []hostLabel, error) {
+	p := strings.TrimSuffix(pattern, ".")
+	if p == "" {
+		return "", nil, errors.New("host pattern is empty")
+	}
+	parts := strings.Split(p, ".")
+	labels := make([]hostLabel, len(parts))
+	for i, part := range parts {
+		switch {
+		case part == "":
+			return "", nil, fmt.Errorf("empty label in host %q", pattern)
+		case isParamSegment(part):
+			name, cons, err := parseParamSegment(part)
+			if err != nil {
+				return "", nil, fmt.Errorf("host %q: %w", pattern, err)
+			}
+			labels[i] = hostLabel{name: name, cons: cons}
+		case strings.ContainsAny(part, ":{}/"):
+			return "", nil, fmt.Errorf("malformed label %q in host %q", part, pattern)
+		default:
+			parts[i] = strings.ToLower(part)
+			labels[i] = hostLabel{lit: parts[i]}
+		}
+	}
+	return strings.Join(parts, "."), labels, nil
+}
+
+func (ht *hostTree) literal() bool {
+	for _, l := range ht.labels {
+		if l.name != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether host, lower-cased and without port, fits the
+// pattern and appends the captured labels to params.
+func (ht *hostTree) match(host string, params []paramKV) ([]paramKV, bool) {
+	if strings.Count(host, ".")+1 != len(ht.labels) {
+		return params, false
+	}
+	mark := len(params)
+	for _, l := range ht.labels {
+		label, rest, _ := strings.Cut(host, ".")
+		host = rest
+		if l.name == "" {
+			if label != l.lit {
+				return params[:mark], false
+			}
+			continue
+		}
+		if label == "" || !l.cons.ok(label) {
+			return params[:mark], false
+		}
+		params = append(params, paramKV{key: l.name, val: label})
+	}
+	return params, true
+}
+
+// hostTree returns the tree for the canonical host pattern, adding an empty
+// one if needed. Literal hosts are kept ahead of patterns so an exact host
+// always wins over a wildcard that would also match it.
+func (t *routeTable) hostTree(pattern string, labels []hostLabel) (ht *hostTree, created bool) {
+	for _, ht := range t.hosts {
+		if ht.pattern == pattern {
+			return ht, false
+		}
+	}
+	ht = &hostTree{pattern: pattern, labels: labels, routeTree: newRouteTree()}
+	i := len(t.hosts)
+	if ht.literal() {
+		for i > 0 && !t.hosts[i-1].literal() {
+			i--
+		}
+	}
+	t.hosts = append(t.hosts, nil)
+	copy(t.hosts[i+1:], t.hosts[i:])
+	t.hosts[i] = ht
+	return ht, true
+}
+
+func (t *routeTable) hasHostRoutes(pattern string) bool {
+	for _, e := range t.routes {
+		if e.Host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *routeTable) dropHost(ht *hostTree) {
+	for i, h := range t.hosts {
+		if h == ht {
+			t.hosts = append(t.hosts[:i], t.hosts[i+1:]...)
+			return
+		}
+	}
+}
+
+// matchHost picks the route tree for the request's host and records its
+// captures in c.params. Requests for hosts without routes of their own use
+// the default tree.
+func (t *routeTable) matchHost(req *http.Request, c *Context) *routeTree {
+	if len(t.hosts) == 0 {
+		return &t.routeTree
+	}
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host = strings.TrimSuffix(strings.ToLower(stripHostPort(host)), ".")
+	for _, ht := range t.hosts {
+		if ps, ok := ht.match(host, c.params[:0]); ok {
+			c.params = ps
+			return &ht.routeTree
+		}
+	}
+	return &t.routeTree
+}
+
+func stripHostPort(h string) string {
+	if i := strings.LastIndexByte(h, ':'); i >= 0 && strings.IndexByte(h[i:], ']') < 0 {
+		h = h[:i]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+}
@@ -19,13 +19,29 @@ func chain(mws ...Middleware) func(Handler) Handler {
 	}
 }
 
+// WrapMiddleware adapts net/http middleware such as gzip or CORS handlers.
+// The writer and request it passes on replace c.Writer and c.Request for the
+// rest of the chain and are restored once it returns.
+func WrapMiddleware(m func(http.Handler) http.Handler) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) {
+			w, req := c.Writer, c.Request
+			m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				c.Writer, c.Request = w, r
+				next(c)
+			})).ServeHTTP(w, req)
+			c.Writer, c.Request = w, req
+		}
+	}
+}
+
 func Recover() Middleware {
 	return func(next Handler) Handler {
 		return func(btx *Context) {
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("[buff] panic: %v", r)
-					_ = btx.JSON(http.StatusInternalServerError, map[string]any{"error": "internal error"})
+					_ = btx.fail(http.StatusInternalServerError, "internal error")
 				}
 			}()
 			next(btx)
@@ -46,6 +62,30 @@ func Logger() Middleware {
 	}
 }
 
+// BodyLimit rejects requests whose body exceeds n bytes with 413. Bodies of
+// unknown length are cut off at n bytes and reads fail with *http.MaxBytesError.
+func BodyLimit(n int64) Middleware {
+	return func(next Handler) Handler {
+		return func(c *Context) {
+			if !limitRequestBody(c.router, c.Writer, c.Request, n) {
+				return
+			}
+			next(c)
+		}
+	}
+}
+
+func limitRequestBody(rt *Router, w http.ResponseWriter, r *http.Request, n int64) bool {
+	if r.ContentLength > n {
+		_ = (&Context{Writer: w, Request: r, router: rt}).fail(http.StatusRequestEntityTooLarge, "request body too large")
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = http.MaxBytesReader(w, r.Body, n)
+	}
+	return true
+}
+
 type timeoutWriter struct {
 	http.ResponseWriter
 	timedOut atomic.Bool
@@ -67,6 +107,17 @@ func (tw *timeoutWriter) Write(p []byte) (int, error) {
 	return tw.ResponseWriter.Write(p)
 }
 
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.ResponseWriter }
+
+func (tw *timeoutWriter) Flush() { _ = tw.FlushError() }
+
+func (tw *timeoutWriter) FlushError() error {
+	if tw.timedOut.Load() {
+		return http.ErrHandlerTimeout
+	}
+	return flushError(tw.ResponseWriter)
+}
+
 func Timeout(d time.Duration) Middleware {
 	return func(next Handler) Handler {
 		return func(c *Context) {
@@ -92,8 +143,7 @@ func Timeout(d time.Duration) Middleware {
 				return
 			case <-ctx.Done():
 				if tw.markTimedOut() {
-					_ = (&Context{Writer: tw, Request: c.Request}).
-						JSON(http.StatusGatewayTimeout, map[string]string{"error": "timeout"})
+					_ = (&Context{Writer: tw, Request: c.Request, router: c.router}).fail(http.StatusGatewayTimeout, "timeout")
 				}
 				return
 			}
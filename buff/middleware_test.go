@@ -0,0 +1,388 @@
+package buff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEngineMaxBodyBytes(t *testing.T) {
+	e := NewEngine()
+	e.SetMaxBodyBytes(8)
+	e.POST("/upload", func(c *Context) {
+		_, err := io.ReadAll(c.Request.Body)
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			_ = c.Text(http.StatusRequestEntityTooLarge, "too large")
+			return
+		}
+		_ = c.Text(http.StatusOK, "ok")
+	})
+
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("0123456789")))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for oversized Content-Length, got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", io.NopCloser(strings.NewReader("0123456789")))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	e.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected handler to see MaxBytesError for unknown length, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("small")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 under the limit, got %d", rr.Code)
+	}
+}
+
+func TestBodyLimitPerRoute(t *testing.T) {
+	r := NewRouter()
+	ok := func(c *Context) { _ = c.Text(http.StatusOK, "ok") }
+	if err := r.Handle(http.MethodPost, "/small", ok, BodyLimit(4)); err != nil {
+		t.Fatalf("register route: %v", err)
+	}
+	if err := r.Handle(http.MethodPost, "/large", ok); err != nil {
+		t.Fatalf("register route: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/small", strings.NewReader("too long")))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/large", strings.NewReader("too long")))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 on unlimited route, got %d", rr.Code)
+	}
+}
+
+func TestContextWriterFlushes(t *testing.T) {
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/stream", func(c *Context) {
+		f, ok := c.Writer.(http.Flusher)
+		if !ok {
+			t.Fatalf("context writer does not implement http.Flusher")
+		}
+		_, _ = io.WriteString(c.Writer, "chunk")
+		f.Flush()
+	}, Timeout(time.Second))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stream", nil))
+	if !rr.Flushed {
+		t.Fatalf("expected flush to reach the underlying writer")
+	}
+}
+
+func TestFallbackHandlersRunThroughMiddleware(t *testing.T) {
+	e := NewEngine()
+	var seen []string
+	e.NotFound(func(c *Context) { _ = c.Text(http.StatusNotFound, "custom 404") })
+	e.MethodNotAllowed(func(c *Context) {
+		_ = c.Text(http.StatusMethodNotAllowed, "custom 405 allow="+c.Writer.Header().Get("Allow"))
+	})
+	// Registered after the handlers on purpose: the stack is applied either way.
+	e.Use(func(next Handler) Handler {
+		return func(c *Context) {
+			next(c)
+			seen = append(seen, fmt.Sprintf("%s %d", c.Request.Method, c.sw.Status()))
+		}
+	})
+	e.GET("/items", func(c *Context) { _ = c.Text(http.StatusOK, "ok") })
+
+	cases := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/nope", "custom 404"},
+		{http.MethodPost, "/items", "custom 405 allow=GET, HEAD, OPTIONS"},
+		{http.MethodOptions, "/items", ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Body.String() != tc.body {
+			t.Fatalf("%s %s: unexpected body %q", tc.method, tc.path, rr.Body.String())
+		}
+	}
+	want := []string{"GET 404", "POST 405", "OPTIONS 204"}
+	if fmt.Sprint(seen) != fmt.Sprint(want) {
+		t.Fatalf("middleware saw %v, want %v", seen, want)
+	}
+}
+
+func TestUseAppliesToEarlierRoutes(t *testing.T) {
+	e := NewEngine()
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(c *Context) {
+				c.Writer.Header().Add("X-Chain", name)
+				next(c)
+			}
+		}
+	}
+	e.GET("/early", func(c *Context) { _ = c.Text(http.StatusOK, "ok") })
+	_ = e.R.Handle(http.MethodGet, "/own", func(c *Context) { _ = c.Text(http.StatusOK, "ok") }, tag("route"))
+	e.Use(tag("engine"))
+	e.R.Use(tag("router"))
+	e.GET("/late", func(c *Context) { _ = c.Text(http.StatusOK, "ok") })
+	e.R.Freeze()
+
+	for path, want := range map[string]string{
+		"/early":   "router,engine",
+		"/late":    "router,engine",
+		"/own":     "router,engine,route",
+		"/missing": "router,engine",
+	} {
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if got := strings.Join(rr.Header().Values("X-Chain"), ","); got != want {
+			t.Fatalf("%s: chain %q, want %q", path, got, want)
+		}
+	}
+	for _, ri := range e.R.Routes() {
+		want := 2
+		if ri.Path == "/own" {
+			want = 3
+		}
+		if ri.Middlewares != want {
+			t.Fatalf("%s: expected %d middlewares, got %d", ri.Path, want, ri.Middlewares)
+		}
+	}
+}
+
+func TestWrapMiddleware(t *testing.T) {
+	type ctxKey struct{}
+	std := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Std", "1")
+			if r.Header.Get("X-Block") != "" {
+				http.Error(w, "blocked", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ctxKey{}, "seen")))
+		})
+	}
+	var status int
+	e := NewEngine()
+	e.Use(func(next Handler) Handler {
+		return func(c *Context) {
+			next(c)
+			status = c.sw.Status()
+		}
+	}, WrapMiddleware(std))
+	e.GET("/x", func(c *Context) {
+		v, _ := c.Request.Context().Value(ctxKey{}).(string)
+		_ = c.Text(http.StatusOK, v)
+	})
+
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "seen" || rr.Header().Get("X-Std") != "1" {
+		t.Fatalf("pass through: %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/x", nil)
+	req.Header.Set("X-Block", "1")
+	rr = httptest.NewRecorder()
+	e.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden || status != http.StatusForbidden {
+		t.Fatalf("short circuit: recorder %d, tracked %d", rr.Code, status)
+	}
+}
+
+func TestChainNextAbort(t *testing.T) {
+	var trace []string
+	step := func(name string) Handler {
+		return func(c *Context) {
+			trace = append(trace, name)
+			c.Next()
+			trace = append(trace, name+fmt.Sprintf("(aborted=%v)", c.IsAborted()))
+		}
+	}
+	auth := func(c *Context) {
+		if c.Request.Header.Get("Authorization") == "" {
+			_ = c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+		}
+	}
+	wrapped := func(next Handler) Handler {
+		return func(c *Context) {
+			trace = append(trace, "wrap")
+			next(c)
+		}
+	}
+
+	e := NewEngine()
+	e.Use(AsMiddleware(step("outer")))
+	e.GET("/x", Chain(step("inner"), AsHandler(wrapped), auth, func(c *Context) {
+		trace = append(trace, "handler")
+		_ = c.Text(http.StatusOK, "ok")
+	}))
+	e.GET("/blocked", Chain(AsHandler(BodyLimit(1)), func(c *Context) {
+		trace = append(trace, "handler")
+	}))
+
+	cases := []struct {
+		path, auth string
+		code       int
+		want       string
+	}{
+		{"/x", "token", http.StatusOK, "outer,inner,wrap,handler,inner(aborted=false),outer(aborted=false)"},
+		{"/x", "", http.StatusUnauthorized, "outer,inner,wrap,inner(aborted=true),outer(aborted=true)"},
+		{"/blocked", "", http.StatusRequestEntityTooLarge, "outer,outer(aborted=true)"},
+	}
+	for _, tc := range cases {
+		trace = nil
+		req := httptest.NewRequest(http.MethodGet, tc.path, strings.NewReader("too long"))
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, req)
+		if rr.Code != tc.code || strings.Join(trace, ",") != tc.want {
+			t.Fatalf("%s auth=%q: %d %v, want %d %s", tc.path, tc.auth, rr.Code, trace, tc.code, tc.want)
+		}
+	}
+}
+
+func TestErrHandlerRendering(t *testing.T) {
+	var seen int
+	e := NewEngine()
+	e.SetMaxBodyBytes(16)
+	e.Use(func(next Handler) Handler {
+		return func(c *Context) {
+			next(c)
+			seen = c.sw.Status()
+		}
+	})
+	e.POST("/bind", WrapErr(func(c *Context) error {
+		var v struct{ Name string }
+		if err := c.Bind(&v); err != nil {
+			return err
+		}
+		return c.Text(http.StatusOK, v.Name)
+	}))
+	e.GET("/teapot", WrapErr(func(c *Context) error { return NewHTTPError(http.StatusTeapot, "short and stout") }))
+	e.GET("/slow", WrapErr(func(c *Context) error { return fmt.Errorf("query: %w", context.DeadlineExceeded) }))
+	e.GET("/boom", WrapErr(func(c *Context) error { return errors.New("db down") }))
+	e.GET("/written", WrapErr(func(c *Context) error {
+		_ = c.Text(http.StatusAccepted, "partial")
+		return errors.New("late")
+	}))
+	deny := func(next Handler) Handler {
+		return func(c *Context) { c.Error(NewHTTPError(http.StatusForbidden)) }
+	}
+	e.GET("/denied", func(c *Context) {}, deny)
+
+	cases := []struct {
+		method, path, body string
+		code               int
+		want               string
+	}{
+		{http.MethodPost, "/bind", `{"Name":"ok"}`, http.StatusOK, "ok"},
+		{http.MethodPost, "/bind", `{"Name":`, http.StatusBadRequest, `{"error":"invalid request body: unexpected EOF"}`},
+		{http.MethodPost, "/bind", `{"Name":"far too long"}`, http.StatusRequestEntityTooLarge, ""},
+		{http.MethodGet, "/teapot", "", http.StatusTeapot, `{"error":"short and stout"}`},
+		{http.MethodGet, "/slow", "", http.StatusGatewayTimeout, `{"error":"timeout"}`},
+		{http.MethodGet, "/boom", "", http.StatusInternalServerError, `{"error":"internal error"}`},
+		{http.MethodGet, "/written", "", http.StatusAccepted, "partial"},
+		{http.MethodGet, "/denied", "", http.StatusForbidden, `{"error":"Forbidden"}`},
+	}
+	for _, tc := range cases {
+		seen = 0
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		req.ContentLength = -1 // exercise the streaming limit rather than the early 413
+		e.ServeHTTP(rr, req)
+		if rr.Code != tc.code || (tc.want != "" && strings.TrimSpace(rr.Body.String()) != tc.want) {
+			t.Fatalf("%s %s: %d %q", tc.method, tc.path, rr.Code, rr.Body.String())
+		}
+		if tc.path != "/denied" && seen != tc.code {
+			t.Fatalf("%s %s: middleware saw %d, want %d", tc.method, tc.path, seen, tc.code)
+		}
+	}
+
+	e.ErrorHandler(func(c *Context, err error) {
+		_ = c.Text(http.StatusServiceUnavailable, "custom: "+err.Error())
+	})
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	if rr.Code != http.StatusServiceUnavailable || rr.Body.String() != "custom: db down" {
+		t.Fatalf("custom handler: %d %q", rr.Code, rr.Body.String())
+	}
+
+	// A handler that only reports must still run once per failed request.
+	calls := 0
+	e.ErrorHandler(func(c *Context, err error) { calls++ })
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/boom", nil))
+	if calls != 1 {
+		t.Fatalf("error handler ran %d times, want 1", calls)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	e := NewEngine()
+	e.SetMaxBodyBytes(4)
+	e.GET("/conflict", func(c *Context) {
+		_ = c.Problem(Problem{Status: http.StatusConflict, Detail: "email taken", Extensions: map[string]any{"field": "email", "status": "ignored"}})
+	})
+	e.GET("/panic", func(c *Context) { panic("boom") })
+	e.GET("/returned", WrapErr(func(c *Context) error {
+		return &Problem{Type: "https://example.com/probs/quota", Title: "Quota exceeded", Status: http.StatusTooManyRequests}
+	}))
+	e.GET("/untitled", func(c *Context) {
+		_ = c.Problem(Problem{Type: "https://example.com/probs/untitled", Status: http.StatusBadRequest})
+	})
+	e.POST("/upload", func(c *Context) {})
+
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rr.Code != http.StatusNotFound || strings.TrimSpace(rr.Body.String()) != `{"error":"route not found"}` {
+		t.Fatalf("default format: %d %q", rr.Code, rr.Body.String())
+	}
+
+	e.SetProblemDetails(true)
+	cases := []struct {
+		method, path, body string
+		want               string
+	}{
+		{http.MethodGet, "/conflict", "", `{"detail":"email taken","field":"email","instance":"/conflict","status":409,"title":"Conflict","type":"about:blank"}`},
+		{http.MethodGet, "/missing", "", `{"detail":"route not found","instance":"/missing","status":404,"title":"Not Found","type":"about:blank"}`},
+		{http.MethodPut, "/conflict", "", `{"detail":"method not allowed","instance":"/conflict","status":405,"title":"Method Not Allowed","type":"about:blank"}`},
+		{http.MethodGet, "/panic", "", `{"detail":"internal error","instance":"/panic","status":500,"title":"Internal Server Error","type":"about:blank"}`},
+		{http.MethodGet, "/returned", "", `{"instance":"/returned","status":429,"title":"Quota exceeded","type":"https://example.com/probs/quota"}`},
+		{http.MethodPost, "/upload", "too large", `{"detail":"request body too large","instance":"/upload","status":413,"title":"Request Entity Too Large","type":"about:blank"}`},
+		{http.MethodGet, "/untitled", "", `{"instance":"/untitled","status":400,"type":"https://example.com/probs/untitled"}`},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Fatalf("%s %s: content type %q", tc.method, tc.path, ct)
+		}
+		if got := strings.TrimSpace(rr.Body.String()); got != tc.want {
+			t.Fatalf("%s %s:\n got %s\nwant %s", tc.method, tc.path, got, tc.want)
+		}
+	}
+	for p, want := range map[*Problem]string{
+		{Title: "Quota exceeded", Detail: "10/10"}:         "Quota exceeded: 10/10",
+		{Type: "https://example.com/probs/x", Status: 409}: "Conflict",
+		{Detail: "boom"}: "Internal Server Error: boom",
+		{Status: 599}:    "599",
+	} {
+		if got := p.Error(); got != want {
+			t.Fatalf("Error() = %q, want %q", got, want)
+		}
+	}
+}
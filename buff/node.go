@@ -6,14 +6,18 @@ import (
 )
 
 type node struct {
-	part     string
-	pchild   *node // :param (最多一个)
-	schild   *node // *splat (最多一个，且终止)
-	children map[string]*node
-	wildcard bool
-	splat    bool
-	handlers map[string]Handler // method -> handler
-	tpls     map[string]string  // method -> route template
+	part      string
+	pchildren []*node // :param / {param:constraint}，受约束的排在前面
+	mchildren []*node // 段内参数，如 :name.:ext、v:version
+	schild    *node   // *splat (最多一个，且终止)
+	children  map[string]*node
+	wildcard  bool
+	splat     bool
+	pname     string             // 参数名
+	pcons     *paramConstraint   // nil 表示匹配任意段
+	pattern   *segPattern        // 段内参数的匹配模式
+	handlers  map[string]Handler // method -> handler
+	tpls      map[string]string  // method -> route template
 }
 
 func newNode(part string) *node {
@@ -30,13 +34,33 @@ func (n *node) add(method string, parts []string, h Handler, tpl string) error {
 		return nil
 	}
 	p := parts[0]
+	if isParamSegment(p) || isPatternSegment(p) {
+		// The same route spelled with other param names could never be
+		// reached, as the one registered first always matches.
+		if other, ok := n.sameShape(method, parts); ok {
+			return fmt.Errorf("route exists: %s %s", method, other)
+		}
+	}
 	switch {
-	case strings.HasPrefix(p, ":"):
-		if n.pchild == nil {
-			n.pchild = newNode(p)
-			n.pchild.wildcard = true
+	case isParamSegment(p):
+		ch, err := n.paramChild(p)
+		if err != nil {
+			return err
+		}
+		return ch.add(method, parts[1:], h, tpl)
+	case isPatternSegment(p):
+		ch := n.patternChild(p)
+		if ch == nil {
+			pat, err := parseSegPattern(p)
+			if err != nil {
+				return err
+			}
+			ch = newNode(p)
+			ch.wildcard = true
+			ch.pattern = pat
+			n.mchildren = append(n.mchildren, ch)
 		}
-		return n.pchild.add(method, parts[1:], h, tpl)
+		return ch.add(method, parts[1:], h, tpl)
 	case strings.HasPrefix(p, "*"):
 		if len(parts) > 1 {
 			return fmt.Errorf("splat must be terminal: %v", parts)
@@ -56,8 +80,115 @@ func (n *node) add(method string, parts []string, h Handler, tpl string) error {
 	}
 }
 
-func (n *node) findPath(path string, i, j int, params []paramKV) (*node, []paramKV) {
+// paramChild returns the param child for segment p, creating it if needed.
+// Params with the same name and constraint share a node; constrained params
+// are tried before unconstrained ones, otherwise in registration order.
+func (n *node) paramChild(p string) (*node, error) {
+	name, cons, err := parseParamSegment(p)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range n.pchildren {
+		if ch.pname == name && ch.pcons.String() == cons.String() {
+			return ch, nil
+		}
+	}
+	ch := newNode(p)
+	ch.wildcard = true
+	ch.pname, ch.pcons = name, cons
+	i := len(n.pchildren)
+	if cons != nil {
+		for i > 0 && n.pchildren[i-1].pcons == nil {
+			i--
+		}
+	}
+	n.pchildren = append(n.pchildren, nil)
+	copy(n.pchildren[i+1:], n.pchildren[i:])
+	n.pchildren[i] = ch
+	return ch, nil
+}
+
+// sameShape looks below n for a route serving method that differs from
+// parts only in its param names and returns its template.
+func (n *node) sameShape(method string, parts []string) (string, bool) {
+	if len(parts) == 0 {
+		if _, ok := n.handlers[method]; ok {
+			return n.tpls[method], true
+		}
+		return "", false
+	}
+	p := parts[0]
+	var alts []*node
+	switch {
+	case isParamSegment(p):
+		alts = n.pchildren
+	case isPatternSegment(p):
+		alts = n.mchildren
+	case strings.HasPrefix(p, "*"):
+		return "", false
+	default:
+		if ch := n.children[p]; ch != nil {
+			return ch.sameShape(method, parts[1:])
+		}
+		return "", false
+	}
+	shape := segmentShape(p)
+	for _, ch := range alts {
+		if segmentShape(ch.part) != shape {
+			continue
+		}
+		if tpl, ok := ch.sameShape(method, parts[1:]); ok {
+			return tpl, true
+		}
+	}
+	return "", false
+}
+
+// segmentShape returns a param or pattern segment with its param names
+// dropped, keeping literals and constraints. Malformed segments are returned
+// as is; add reports them.
+func segmentShape(p string) string {
+	if isParamSegment(p) {
+		_, cons, err := parseParamSegment(p)
+		if err != nil {
+			return p
+		}
+		return "{:" + cons.String() + "}"
+	}
+	pat, err := parseSegPattern(p)
+	if err != nil {
+		return p
+	}
+	var b strings.Builder
+	for _, tok := range pat.tokens {
+		if tok.isParam() {
+			b.WriteString("{:" + tok.cons.String() + "}")
+		} else {
+			b.WriteString(tok.lit)
+		}
+	}
+	return b.String()
+}
+
+func (n *node) patternChild(p string) *node {
+	for _, ch := range n.mchildren {
+		if ch.part == p {
+			return ch
+		}
+	}
+	return nil
+}
+
+// findPath matches path[i:j] below n and returns the node holding a handler
+// for method, or nil; an empty method accepts a node with any handler.
+// Alternatives are tried static first, then segments with embedded params,
+// then whole-segment params in order, then the splat, backtracking whenever
+// the rest of the path does not match.
+func (n *node) findPath(method, path string, i, j int, params []paramKV) (*node, []paramKV) {
 	if i >= j {
+		if !n.serves(method) {
+			return nil, params
+		}
 		return n, params
 	}
 	k := i
@@ -65,25 +196,84 @@ func (n *node) findPath(path string, i, j int, params []paramKV) (*node, []param
 		k++
 	}
 	seg := path[i:k]
+	mark := len(params)
 
 	// static
 	if ch := n.children[seg]; ch != nil {
-		return ch.findPath(path, nextIndex(k, j), j, params)
+		if leaf, ps := ch.findPath(method, path, nextIndex(k, j), j, params); leaf != nil {
+			return leaf, ps
+		}
+	}
+
+	// embedded params
+	for _, ch := range n.mchildren {
+		ps, ok := ch.pattern.match(seg, params[:mark])
+		if !ok {
+			continue
+		}
+		if leaf, ps := ch.findPath(method, path, nextIndex(k, j), j, ps); leaf != nil {
+			return leaf, ps
+		}
+	}
+
+	// param
+	for _, ch := range n.pchildren {
+		if !ch.pcons.ok(seg) {
+			continue
+		}
+		params = append(params[:mark], paramKV{key: ch.pname, val: seg})
+		if leaf, ps := ch.findPath(method, path, nextIndex(k, j), j, params); leaf != nil {
+			return leaf, ps
+		}
 	}
 
 	// splat
-	if n.schild != nil {
+	if n.schild != nil && n.schild.serves(method) {
 		key := strings.TrimPrefix(n.schild.part, "*")
-		params = append(params, paramKV{key: key, val: path[i:j]})
+		params = append(params[:mark], paramKV{key: key, val: path[i:j]})
 		return n.schild, params
 	}
-	// param
-	if n.pchild != nil {
-		key := strings.TrimPrefix(n.pchild.part, ":")
-		params = append(params, paramKV{key: key, val: seg})
-		return n.pchild.findPath(path, nextIndex(k, j), j, params)
+	return nil, params[:mark]
+}
+
+func (n *node) serves(method string) bool {
+	if method == "" {
+		return len(n.handlers) > 0
+	}
+	return n.handlers[method] != nil
+}
+
+// collectMethods adds the methods of every route matching path[i:j] to set.
+func (n *node) collectMethods(path string, i, j int, set map[string]bool) {
+	if i >= j {
+		for m := range n.handlers {
+			set[m] = true
+		}
+		return
+	}
+	k := i
+	for k < j && path[k] != '/' {
+		k++
+	}
+	seg := path[i:k]
+	if ch := n.children[seg]; ch != nil {
+		ch.collectMethods(path, nextIndex(k, j), j, set)
+	}
+	for _, ch := range n.mchildren {
+		if _, ok := ch.pattern.match(seg, nil); ok {
+			ch.collectMethods(path, nextIndex(k, j), j, set)
+		}
+	}
+	for _, ch := range n.pchildren {
+		if ch.pcons.ok(seg) {
+			ch.collectMethods(path, nextIndex(k, j), j, set)
+		}
+	}
+	if n.schild != nil {
+		for m := range n.schild.handlers {
+			set[m] = true
+		}
 	}
-	return nil, params
 }
 
 func nextIndex(k, j int) int {
@@ -92,3 +282,112 @@ func nextIndex(k, j int) int {
 	}
 	return k
 }
+
+// findFold matches segs against the trie with static segments compared
+// case-insensitively and returns them spelled as registered. Only nodes that
+// carry handlers count as a match.
+func (n *node) findFold(segs []string, out []string) ([]string, bool) {
+	if len(segs) == 0 {
+		return out, len(n.handlers) > 0
+	}
+	seg := segs[0]
+	for part, ch := range n.children {
+		if strings.EqualFold(part, seg) {
+			if res, ok := ch.findFold(segs[1:], append(out, part)); ok {
+				return res, true
+			}
+		}
+	}
+	for _, ch := range n.mchildren {
+		if _, ok := ch.pattern.match(seg, nil); !ok {
+			continue
+		}
+		if res, ok := ch.findFold(segs[1:], append(out, seg)); ok {
+			return res, true
+		}
+	}
+	for _, ch := range n.pchildren {
+		if !ch.pcons.ok(seg) {
+			continue
+		}
+		if res, ok := ch.findFold(segs[1:], append(out, seg)); ok {
+			return res, true
+		}
+	}
+	if n.schild != nil && len(n.schild.handlers) > 0 {
+		return append(out, segs...), true
+	}
+	return nil, false
+}
+
+// exact returns the node registered for the template parts, or nil.
+func (n *node) exact(parts []string) *node {
+	if len(parts) == 0 {
+		return n
+	}
+	p := parts[0]
+	var ch *node
+	switch {
+	case isParamSegment(p):
+		name, cons, err := parseParamSegment(p)
+		if err != nil {
+			return nil
+		}
+		for _, c := range n.pchildren {
+			if c.pname == name && c.pcons.String() == cons.String() {
+				ch = c
+			}
+		}
+	case isPatternSegment(p):
+		ch = n.patternChild(p)
+	case strings.HasPrefix(p, "*"):
+		if n.schild != nil && n.schild.part == p {
+			ch = n.schild
+		}
+	default:
+		ch = n.children[p]
+	}
+	if ch == nil {
+		return nil
+	}
+	return ch.exact(parts[1:])
+}
+
+// remove deletes the handler for method at the template parts and prunes
+// nodes left without routes. It reports whether n itself became empty.
+func (n *node) remove(method string, parts []string) bool {
+	if len(parts) == 0 {
+		delete(n.handlers, method)
+		delete(n.tpls, method)
+		return n.empty()
+	}
+	ch := n.exact(parts[:1])
+	if ch == nil || !ch.remove(method, parts[1:]) {
+		return false
+	}
+	switch {
+	case ch == n.schild:
+		n.schild = nil
+	case ch.pattern != nil:
+		n.mchildren = deleteNode(n.mchildren, ch)
+	case ch.wildcard:
+		n.pchildren = deleteNode(n.pchildren, ch)
+	default:
+		delete(n.children, ch.part)
+	}
+	return n.empty()
+}
+
+func (n *node) empty() bool {
+	return len(n.handlers) == 0 && len(n.children) == 0 && len(n.mchildren) == 0 &&
+		len(n.pchildren) == 0 && n.schild == nil
+}
+
+func deleteNode(ns []*node, n *node) []*node {
+	for i, c := range ns {
+		if c == n {
+			return append(ns[:i], ns[i+1:]...)
+		}
+	}
+	return ns
+}
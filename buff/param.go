@@ -0,0 +1,267 @@
+package buff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// paramConstraint restricts which segments a route parameter matches. It is
+// declared as {name:type} with one of the names in paramTypes, or as
+// {name:regexp}, where the expression must match the whole segment.
+type paramConstraint struct {
+	spec  string
+	match func(string) bool
+}
+
+var paramTypes = map[string]func(string) bool{
+	"int": func(s string) bool {
+		return isDigits(strings.TrimPrefix(s, "-"))
+	},
+	"uint": isDigits,
+	"alpha": func(s string) bool {
+		return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isASCIILetter(r) }) < 0
+	},
+	"alnum": func(s string) bool {
+		return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isASCIILetter(r) && !isASCIIDigit(r) }) < 0
+	},
+	"uuid": isUUID,
+}
+
+// isParamSegment reports whether p is a single param spanning the whole
+// segment. A ":name" segment qualifies only if its name runs to the end, so
+// ":name.json" is a pattern with the literal ".json" after the param.
+func isParamSegment(p string) bool {
+	switch {
+	case strings.HasPrefix(p, ":"):
+		for i := 1; i < len(p); i++ {
+			if !isParamNameChar(p[i]) {
+				return false
+			}
+		}
+		return true
+	case strings.HasPrefix(p, "{"):
+		return closingBrace(p) == len(p)-1
+	}
+	return false
+}
+
+// isPatternSegment reports whether p embeds params between literals, as in
+// ":name.:ext", "v:version" or "{id:int}.json".
+func isPatternSegment(p string) bool {
+	return !strings.HasPrefix(p, "*") && !isParamSegment(p) && strings.ContainsAny(p, ":{")
+}
+
+// closingBrace returns the index of the brace closing p[0], allowing nested
+// braces inside a regexp constraint, or -1.
+func closingBrace(p string) int {
+	depth := 0
+	for i := 0; i < len(p); i++ {
+		switch p[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// segPattern is a segment made of literals and params. Within a pattern a
+// ":name" ends at the first character that is not a letter, digit or '_'.
+type segPattern struct {
+	tokens []segToken
+}
+
+type segToken struct {
+	lit  string
+	name string
+	cons *paramConstraint
+}
+
+func (t segToken) isParam() bool { return t.name != "" }
+
+func parseSegPattern(p string) (*segPattern, error) {
+	sp := &segPattern{}
+	for i := 0; i < len(p); {
+		var tok segToken
+		switch p[i] {
+		case ':':
+			j := i + 1
+			for j < len(p) && isParamNameChar(p[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("param name missing in %q", p)
+			}
+			tok.name, i = p[i+1:j], j
+		case '{':
+			end := closingBrace(p[i:])
+			if end < 0 {
+				return nil, fmt.Errorf("malformed param %q", p)
+			}
+			name, cons, err := parseParamSegment(p[i : i+end+1])
+			if err != nil {
+				return nil, err
+			}
+			tok.name, tok.cons, i = name, cons, i+end+1
+		default:
+			j := i
+			for j < len(p) && p[j] != ':' && p[j] != '{' {
+				j++
+			}
+			tok.lit, i = p[i:j], j
+		}
+		if n := len(sp.tokens); n > 0 && tok.isParam() && sp.tokens[n-1].isParam() {
+			return nil, fmt.Errorf("params must be separated by a literal in %q", p)
+		}
+		sp.tokens = append(sp.tokens, tok)
+	}
+	return sp, nil
+}
+
+func isParamNameChar(c byte) bool {
+	return c == '_' || isASCIIDigit(rune(c)) || isASCIILetter(rune(c))
+}
+
+// match appends the params captured from seg. Each param is non-empty and
+// ends at the first occurrence of the following literal that lets the rest
+// of the pattern match.
+func (sp *segPattern) match(seg string, params []paramKV) ([]paramKV, bool) {
+	return sp.matchFrom(0, seg, params)
+}
+
+func (sp *segPattern) matchFrom(t int, s string, params []paramKV) ([]paramKV, bool) {
+	if t == len(sp.tokens) {
+		return params, s == ""
+	}
+	tok := sp.tokens[t]
+	if !tok.isParam() {
+		if !strings.HasPrefix(s, tok.lit) {
+			return params, false
+		}
+		return sp.matchFrom(t+1, s[len(tok.lit):], params)
+	}
+	if t == len(sp.tokens)-1 {
+		if s == "" || !tok.cons.ok(s) {
+			return params, false
+		}
+		return append(params, paramKV{key: tok.name, val: s}), true
+	}
+	next := sp.tokens[t+1].lit
+	mark := len(params)
+	for end := 1; end+len(next) <= len(s); end++ {
+		if !strings.HasPrefix(s[end:], next) || !tok.cons.ok(s[:end]) {
+			continue
+		}
+		if ps, ok := sp.matchFrom(t+1, s[end:], append(params[:mark], paramKV{key: tok.name, val: s[:end]})); ok {
+			return ps, true
+		}
+	}
+	return params[:mark], false
+}
+
+// parseParamSegment splits ":name", "{name}" or "{name:constraint}".
+func parseParamSegment(p string) (string, *paramConstraint, error) {
+	if strings.HasPrefix(p, ":") {
+		if len(p) == 1 {
+			return "", nil, fmt.Errorf("param name missing in %q", p)
+		}
+		return p[1:], nil, nil
+	}
+	if len(p) < 3 || p[len(p)-1] != '}' {
+		return "", nil, fmt.Errorf("malformed param %q", p)
+	}
+	name, spec, hasSpec := strings.Cut(p[1:len(p)-1], ":")
+	if name == "" {
+		return "", nil, fmt.Errorf("param name missing in %q", p)
+	}
+	if !hasSpec {
+		return name, nil, nil
+	}
+	if fn, ok := paramTypes[spec]; ok {
+		return name, &paramConstraint{spec: spec, match: fn}, nil
+	}
+	re, err := regexp.Compile("^(?:" + spec + ")$")
+	if err != nil {
+		return "", nil, fmt.Errorf("param %q: %w", name, err)
+	}
+	return name, &paramConstraint{spec: spec, match: re.MatchString}, nil
+}
+
+func (pc *paramConstraint) String() string {
+	if pc == nil {
+		return ""
+	}
+	return pc.spec
+}
+
+func (pc *paramConstraint) ok(seg string) bool {
+	return pc == nil || pc.match(seg)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isASCIIDigit(rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIIDigit(r rune) bool  { return r >= '0' && r <= '9' }
+func isASCIILetter(r rune) bool { return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' }
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !isASCIIDigit(rune(c)) && (c|0x20 < 'a' || c|0x20 > 'f') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ParamInt returns the named path parameter parsed as a base-10 int.
+func (c *Context) ParamInt(k string) (int, error) {
+	v, err := strconv.Atoi(c.Param(k))
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", k, err)
+	}
+	return v, nil
+}
+
+// ParamInt64 returns the named path parameter parsed as a base-10 int64.
+func (c *Context) ParamInt64(k string) (int64, error) {
+	v, err := strconv.ParseInt(c.Param(k), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", k, err)
+	}
+	return v, nil
+}
+
+// ParamUint64 returns the named path parameter parsed as a base-10 uint64.
+func (c *Context) ParamUint64(k string) (uint64, error) {
+	v, err := strconv.ParseUint(c.Param(k), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("param %s: %w", k, err)
+	}
+	return v, nil
+}
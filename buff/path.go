@@ -0,0 +1,98 @@
+package buff
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// PathPolicy controls how the router treats request paths that differ from
+// their canonical form: no trailing slash, no empty, "." or ".." segments.
+type PathPolicy int
+
+const (
+	// PathNormalize strips trailing slashes and collapses "//" before matching.
+	PathNormalize PathPolicy = iota
+	// PathStrict only matches canonical paths; anything else is a 404. It
+	// governs requests only: route templates are canonicalized whatever the
+	// policy, so "/users/" and "/users" are the same route and cannot be
+	// registered separately.
+	PathStrict
+	// PathRedirect answers non-canonical paths, and paths that match a route
+	// only when compared case-insensitively, with a redirect to the route's
+	// canonical path: 301 for GET and HEAD, 308 otherwise so the method and
+	// body are kept.
+	PathRedirect
+)
+
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	return path.Clean(p)
+}
+
+// redirectTarget returns the path a non-canonical request should be sent to,
+// if any route serves it.
+func (t *routeTree) redirectTarget(canon string) (string, bool) {
+	if len(t.allowedMethods(canon)) > 0 {
+		return canon, true
+	}
+	return t.fixPathCase(canon)
+}
+
+// fixPathCase looks clean up case-insensitively and returns the path spelled
+// as registered.
+func (t *routeTree) fixPathCase(clean string) (string, bool) {
+	for _, mm := range t.fast {
+		for p := range mm {
+			if strings.EqualFold(p, clean) {
+				return p, true
+			}
+		}
+	}
+	if segs, ok := t.root.findFold(splitPath(clean), nil); ok {
+		return "/" + strings.Join(segs, "/"), true
+	}
+	return "", false
+}
+
+// redirectLocation returns the escaped path to send req to once target, a
+// decoded path, was found to serve it. A target equal to canon, the cleaned
+// request path, keeps the client's own escaping; a case-corrected one is
+// escaped segment by segment. It never starts with "//" or "/\", which
+// browsers take for another host.
+func redirectLocation(req *http.Request, canon, target string) string {
+	var loc string
+	if target == canon {
+		loc = cleanPath(req.URL.EscapedPath())
+	} else {
+		segs := splitPath(target)
+		for i, s := range segs {
+			segs[i] = url.PathEscape(s)
+		}
+		loc = "/" + strings.Join(segs, "/")
+	}
+	if len(loc) > 1 && (loc[1] == '/' || loc[1] == '\\') {
+		loc = "/" + strings.TrimLeft(loc, `/\`)
+	}
+	return loc
+}
+
+// redirectToRoute sends the client to c.Route, which holds the escaped
+// target, keeping the query string.
+func redirectToRoute(c *Context) {
+	code := http.StatusPermanentRedirect
+	if m := c.Request.Method; m == http.MethodGet || m == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	target := c.Route
+	if q := c.Request.URL.RawQuery; q != "" {
+		target += "?" + q
+	}
+	_ = c.Redirect(code, target)
+}
@@ -0,0 +1,107 @@
+package buff
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+const problemContentType = "application/problem+json"
+
+// Problem is an RFC 9457 problem details object. Extensions are added as
+// extra members; they cannot override the standard ones.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]any
+}
+
+// Error returns the title, or the status text without one, followed by the
+// detail.
+func (p *Problem) Error() string {
+	title := p.Title
+	if title == "" {
+		status := p.normalized("").Status
+		if title = http.StatusText(status); title == "" {
+			title = strconv.Itoa(status)
+		}
+	}
+	if p.Detail != "" {
+		return title + ": " + p.Detail
+	}
+	return title
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	m["status"] = p.Status
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// normalized fills the defaults: status 500, type "about:blank", the status
+// text as title for that type, and instance as given.
+func (p Problem) normalized(instance string) Problem {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	if p.Title == "" && p.Type == "about:blank" {
+		p.Title = http.StatusText(p.Status)
+	}
+	if p.Instance == "" {
+		p.Instance = instance
+	}
+	return p
+}
+
+// Problem responds with p as application/problem+json. Missing fields get
+// their RFC 9457 defaults, and the instance defaults to the request path.
+//
+//	c.Problem(buff.Problem{Status: 409, Detail: "email already registered"})
+func (c *Context) Problem(p Problem) error {
+	p = p.normalized(c.Request.URL.Path)
+	c.Header("Content-Type", problemContentType)
+	c.Writer.WriteHeader(p.Status)
+	enc := json.NewEncoder(c.Writer)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(p)
+}
+
+// SetProblemDetails makes every error the framework generates itself, such
+// as 404, 405, recovered panics, timeouts, body limits and the errors of the
+// default error handler, use application/problem+json instead of the
+// {"error": "..."} body. The gnet engine applies it to malformed requests
+// too.
+func (r *Router) SetProblemDetails(on bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.edit().problemDetails = on
+}
+
+func (r *Router) problemDetails() bool { return r != nil && r.snapshot().problemDetails }
+
+// fail writes an error generated by the framework in the router's format.
+func (c *Context) fail(code int, msg string) error {
+	if c.router.problemDetails() {
+		return c.Problem(Problem{Status: code, Detail: msg})
+	}
+	return c.JSON(code, map[string]any{"error": msg})
+}
@@ -4,142 +4,488 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 type Router struct {
-	root *node
+	// table is the published snapshot read by ServeHTTP without locking.
+	// Writers edit draft under mu and set dirty; the next request (or
+	// another writer) publishes it. A burst of registrations therefore
+	// costs a single clone.
+	table atomic.Pointer[routeTable]
+	dirty atomic.Bool
+	draft *routeTable
 
-	mw []Middleware
+	// global middleware: mw followed by engineMW wraps every route and the
+	// fallback handlers, whenever it was added. stale is set when it changed
+	// and the compiled chains in the table must be rebuilt on publish.
+	mw       []Middleware
+	engineMW []Middleware
+	stale    bool
 
-	notFound Handler
+	// fallback handlers as registered; the table holds them wrapped in the
+	// global middleware stack.
+	notFound, methodNotAllowed, options Handler
 
 	pool sync.Pool
 
-	fast map[string]map[string]Handler
-
-	mu sync.RWMutex
+	mu sync.Mutex
 }
 
 func NewRouter() *Router {
 	r := &Router{
-		root: newNode("/"),
-		mw:   make([]Middleware, 0),
+		mw: make([]Middleware, 0),
 		notFound: func(btx *Context) {
-			btx.JSON(http.StatusNotFound, map[string]any{"error": "route not found"})
+			_ = btx.fail(http.StatusNotFound, "route not found")
+		},
+		methodNotAllowed: func(btx *Context) {
+			_ = btx.fail(http.StatusMethodNotAllowed, "method not allowed")
+		},
+		options: func(btx *Context) {
+			btx.Writer.WriteHeader(http.StatusNoContent)
 		},
-		fast: make(map[string]map[string]Handler),
 	}
 	r.pool.New = func() any { return &Context{} }
+	r.table.Store(newRouteTable())
+	r.wrapFallbacks()
+	r.publishLocked()
 	return r
 }
 
-func (r *Router) Use(m ...Middleware) { r.mw = append(r.mw, m...) }
+// snapshot returns the current routing table, publishing pending edits.
+func (r *Router) snapshot() *routeTable {
+	if r.dirty.Load() {
+		r.mu.Lock()
+		r.publishLocked()
+		r.mu.Unlock()
+	}
+	return r.table.Load()
+}
+
+// edit returns the draft table for modification; r.mu must be held.
+func (r *Router) edit() *routeTable {
+	if r.draft == nil {
+		r.draft = r.table.Load().clone()
+	}
+	r.dirty.Store(true)
+	return r.draft
+}
+
+func (r *Router) publishLocked() {
+	if r.draft == nil {
+		return
+	}
+	if r.stale {
+		r.recompileLocked(r.draft)
+		r.stale = false
+	}
+	r.table.Store(r.draft)
+	r.draft = nil
+	r.dirty.Store(false)
+}
+
+// Use appends global middleware. It applies to every route, including ones
+// registered earlier, and to the 404/405/OPTIONS handlers.
+func (r *Router) Use(m ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mw = append(r.mw, m...)
+	r.markStale()
+}
+
+// Freeze compiles the middleware chains of all routes and publishes the
+// routing table now instead of on the next request. Routes can still be
+// changed afterwards.
+func (r *Router) Freeze() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.publishLocked()
+}
+
+func (r *Router) markStale() {
+	r.stale = true
+	r.edit()
+}
+
+func (r *Router) globalMiddleware() []Middleware {
+	return append(append(make([]Middleware, 0, len(r.mw)+len(r.engineMW)), r.mw...), r.engineMW...)
+}
+
+// compile wraps h in the global middleware, then the middleware of g and its
+// parents, then the route's own mws. Errors h records are rendered before the
+// middleware returns, so it sees the final status. r.mu must be held.
+func (r *Router) compile(h Handler, g *Group, mws []Middleware) Handler {
+	all := append(r.globalMiddleware(), g.middleware()...)
+	return chain(append(all, mws...)...)(Recover()(renderErrors(h)))
+}
+
+func (r *Router) middlewareCount(g *Group, mws []Middleware) int {
+	return len(r.mw) + len(r.engineMW) + len(g.middleware()) + len(mws)
+}
+
+// recompileLocked rebuilds every route's chain in t with the current global
+// middleware.
+func (r *Router) recompileLocked(t *routeTable) {
+	for key, e := range t.routes {
+		t.tree(e.Host).setHandler(e.Method, e.Path, r.compile(e.h, e.group, e.mws))
+		e.Middlewares = r.middlewareCount(e.group, e.mws)
+		t.routes[key] = e
+	}
+	r.wrapFallbacks()
+}
+
+// NotFound replaces the handler for paths that match no route. It runs
+// through the global middleware stack like any registered route.
+func (r *Router) NotFound(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notFound = h
+	r.wrapFallbacks()
+}
+
+// MethodNotAllowed replaces the handler for paths that exist under other
+// methods. The Allow header is already set when h runs.
+func (r *Router) MethodNotAllowed(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.methodNotAllowed = h
+	r.wrapFallbacks()
+}
+
+// setEngineMiddleware installs the Engine's global middleware around the
+// fallback handlers; routes receive it through Handle.
+func (r *Router) setEngineMiddleware(mws []Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.engineMW = mws
+	r.markStale()
+}
+
+func (r *Router) wrapFallbacks() {
+	wrap := chain(r.globalMiddleware()...)
+	t := r.edit()
+	t.notFoundH = wrap(Recover()(r.notFound))
+	t.methodNotAllowedH = wrap(Recover()(r.methodNotAllowed))
+	t.optionsH = wrap(Recover()(r.options))
+	t.redirectH = wrap(Recover()(redirectToRoute))
+}
+
+// SetPathPolicy chooses how request paths that are not in canonical form are
+// routed. The default is PathNormalize. Registered templates are always
+// canonicalized, so the policy can change at any time.
+func (r *Router) SetPathPolicy(p PathPolicy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.edit().pathPolicy = p
+}
 
 func (r *Router) Handle(method, path string, h Handler, mws ...Middleware) error {
+	return r.HandleNamed("", method, path, h, mws...)
+}
+
+// HandleNamed is Handle for a route that can be referenced by name from URL
+// and Context.URLFor. Names are unique per router.
+func (r *Router) HandleNamed(name, method, path string, h Handler, mws ...Middleware) error {
 	if path == "" || path[0] != '/' {
 		return errors.New("path must start with '/'")
 	}
 	method = strings.ToUpper(method)
 	clean := normalize(path)
 
-	final := chain(append(r.mw, mws...)...)(Recover()(h))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addLocked(name, method, clean, nil, h, mws, false)
+}
+
+// Mount serves an http.Handler below prefix; see Group.Mount.
+func (r *Router) Mount(prefix string, h http.Handler, mws ...Middleware) error {
+	return r.Group("").Mount(prefix, h, mws...)
+}
+
+// Replace swaps the handler of an existing route, or registers it if it does
+// not exist yet. Requests already in flight finish on the old handler.
+func (r *Router) Replace(method, path string, h Handler, mws ...Middleware) error {
+	if path == "" || path[0] != '/' {
+		return errors.New("path must start with '/'")
+	}
+	method = strings.ToUpper(method)
+	clean := normalize(path)
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.addLocked("", method, clean, nil, h, mws, true)
+}
+
+func (r *Router) addLocked(name, method, clean string, g *Group, h Handler, mws []Middleware, replace bool) error {
+	host, labels := "", []hostLabel(nil)
+	if g != nil && g.host != "" {
+		var err error
+		if host, labels, err = parseHostPattern(g.host); err != nil {
+			return err
+		}
+	}
+	t := r.edit()
+	if _, ok := t.names[name]; ok && name != "" {
+		return fmt.Errorf("route name exists: %s", name)
+	}
+	key := routeKey(host, method, clean)
+	prev, exists := t.routes[key]
+	if exists && !replace {
+		return fmt.Errorf("route exists: %s %s%s", method, host, clean)
+	}
 
-	if !strings.ContainsAny(clean, ":*") {
-		mm := r.fast[method]
+	tree := &t.routeTree
+	var ht *hostTree
+	created := false
+	if host != "" {
+		ht, created = t.hostTree(host, labels)
+		tree = &ht.routeTree
+	}
+	final := r.compile(h, g, mws)
+	if exists {
+		tree.setHandler(method, clean, final)
+	} else if !strings.ContainsAny(clean, ":*{") {
+		mm := tree.fast[method]
 		if mm == nil {
 			mm = map[string]Handler{}
-			r.fast[method] = mm
-		}
-		if _, ok := mm[clean]; ok {
-			return fmt.Errorf("route exists: %s %s", method, clean)
+			tree.fast[method] = mm
 		}
 		mm[clean] = final
-		return nil
+	} else if err := tree.root.add(method, splitPath(clean), final, clean); err != nil {
+		if created {
+			t.dropHost(ht)
+		}
+		return err
 	}
 
-	parts := splitPath(clean)
-	if err := r.root.add(method, parts, final, clean); err != nil {
-		return err
+	if name == "" {
+		name = prev.Name
+	}
+	if name != "" {
+		t.names[name] = clean
+	}
+	mws = append([]Middleware(nil), mws...)
+	t.routes[key] = routeEntry{
+		RouteInfo: RouteInfo{Method: method, Path: clean, Name: name, Middlewares: r.middlewareCount(g, mws), Host: host},
+		h:         h,
+		group:     g,
+		mws:       mws,
 	}
 	return nil
 }
 
-type Group struct {
-	r    *Router
-	base string
-	mw   []Middleware
+// Remove unregisters the route for method and path, spelled as registered,
+// from the default host. Routes added through Host are removed with the
+// group's Remove.
+func (r *Router) Remove(method, path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.removeLocked("", strings.ToUpper(method), normalize(path))
+}
+
+// removeLocked unregisters a route of the canonical host pattern, "" for the
+// default host, and drops the host's tree once it has no routes left. r.mu
+// must be held.
+func (r *Router) removeLocked(host, method, clean string) error {
+	key := routeKey(host, method, clean)
+	if _, ok := r.current().routes[key]; !ok {
+		return fmt.Errorf("route not found: %s %s%s", method, host, clean)
+	}
+	t := r.edit()
+	tree := &t.routeTree
+	var ht *hostTree
+	for _, h := range t.hosts {
+		if host != "" && h.pattern == host {
+			ht, tree = h, &h.routeTree
+		}
+	}
+	if mm := tree.fast[method]; mm != nil && mm[clean] != nil {
+		delete(mm, clean)
+		if len(mm) == 0 {
+			delete(tree.fast, method)
+		}
+	} else {
+		tree.root.remove(method, splitPath(clean))
+	}
+	if name := t.routes[key].Name; name != "" {
+		delete(t.names, name)
+	}
+	delete(t.routes, key)
+	if ht != nil && !t.hasHostRoutes(host) {
+		t.dropHost(ht)
+	}
+	return nil
 }
 
-func (r *Router) Group(prefix string, m ...Middleware) *Group {
-	if !strings.HasPrefix(prefix, "/") {
-		prefix = "/" + prefix
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	Method string
+	Path   string // route template
+	Name   string
+	// Middlewares counts the middleware wrapped around the handler, global
+	// and per-route.
+	Middlewares int
+	Host        string // host pattern, "" for the default host
+}
+
+// Routes lists every registered route sorted by host, path, then method.
+func (r *Router) Routes() []RouteInfo {
+	t := r.snapshot()
+	out := make([]RouteInfo, 0, len(t.routes))
+	for _, e := range t.routes {
+		out = append(out, e.RouteInfo)
 	}
-	return &Group{r: r, base: strings.TrimRight(prefix, "/"), mw: m}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Host != out[j].Host {
+			return out[i].Host < out[j].Host
+		}
+		if out[i].Path != out[j].Path {
+			return out[i].Path < out[j].Path
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out
 }
 
-func (g *Group) Handle(method, path string, h Handler, mws ...Middleware) error {
-	full := g.base
-	if path != "" && path != "/" {
-		full += "/" + strings.Trim(path, "/")
+func routeKey(host, method, tpl string) string { return method + " " + host + tpl }
+
+// current returns the table writers see: the draft if there is one. r.mu
+// must be held.
+func (r *Router) current() *routeTable {
+	if r.draft != nil {
+		return r.draft
 	}
-	return g.r.Handle(method, full, h, append(g.mw, mws...)...)
+	return r.table.Load()
 }
 
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.mu.RLock()
-	root := r.root
-	r.mu.RUnlock()
+	t := r.snapshot()
 	method := strings.ToUpper(req.Method)
+	policy := t.pathPolicy
+
+	c := r.getCtx(w, req)
+	tree := t.matchHost(req, c)
+	base := len(c.params)
+	if raw := req.URL.Path; policy != PathNormalize && raw != "" {
+		if canon := cleanPath(raw); canon != raw {
+			c.Route = raw
+			target, ok := "", false
+			if policy == PathRedirect {
+				target, ok = tree.redirectTarget(canon)
+			}
+			if ok {
+				c.Route = redirectLocation(req, canon, target)
+				t.redirectH(c)
+			} else {
+				t.notFoundH(c)
+			}
+			r.putCtx(c)
+			return
+		}
+	}
 	clean := normalize(req.URL.Path)
 
+	h := tree.lookup(method, clean, c, base)
+	if h == nil && method == http.MethodHead {
+		// HEAD falls back to GET; the engines send its headers and drop the body.
+		h = tree.lookup(http.MethodGet, clean, c, base)
+	}
+	if h != nil {
+		h(c)
+		c.renderErrors()
+		r.putCtx(c)
+		return
+	}
+
+	c.Route = clean
+	allowed := tree.allowedMethods(clean)
+	switch {
+	case len(allowed) == 0:
+		if policy == PathRedirect {
+			if fixed, ok := tree.fixPathCase(clean); ok {
+				c.Route = redirectLocation(req, clean, fixed)
+				t.redirectH(c)
+				break
+			}
+		}
+		t.notFoundH(c)
+	case method == http.MethodOptions:
+		c.Header("Allow", strings.Join(allowed, ", "))
+		t.optionsH(c)
+	default:
+		c.Header("Allow", strings.Join(allowed, ", "))
+		t.methodNotAllowedH(c)
+	}
+	c.renderErrors()
+	r.putCtx(c)
+}
+
+// lookup resolves the handler for method on clean and sets c.Route and
+// c.params when one is found. The first base params, captured from the host,
+// are kept.
+func (t *routeTree) lookup(method, clean string, c *Context, base int) Handler {
 	// Fast path
-	if mm := r.fast[method]; mm != nil {
+	if mm := t.fast[method]; mm != nil {
 		if h, ok := mm[clean]; ok {
-			c := r.getCtx(w, req)
+			c.params = c.params[:base]
 			c.Route = clean
-			h(c)
-			r.putCtx(c)
-			return
+			return h
 		}
 	}
 
 	// Slow path
-	c := r.getCtx(w, req)
-	leaf, params := root.findPath(clean, 1, len(clean), c.params[:0])
+	leaf, params := t.root.findPath(method, clean, 1, len(clean), c.params[:base])
 	c.params = params
 	if leaf == nil {
-		c.Route = clean
-		r.notFound(c)
-		r.putCtx(c)
-		return
+		return nil
 	}
-	c.params = c.params[:len(c.params)]
 	h := leaf.handlers[method]
-	if h == nil {
-		c.Route = clean
-		_ = c.JSON(http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
-		r.putCtx(c)
-		return
-	}
 	if tpl, ok := leaf.tpls[method]; ok {
 		c.Route = tpl
 	} else {
 		c.Route = clean
 	}
-	h(c)
-	r.putCtx(c)
+	return h
+}
+
+// allowedMethods lists the methods registered for clean in either the static
+// map or the trie, sorted, plus the implicit HEAD and OPTIONS. It is empty
+// when no route matches the path at all.
+func (t *routeTree) allowedMethods(clean string) []string {
+	set := map[string]bool{}
+	for method, mm := range t.fast {
+		if _, ok := mm[clean]; ok {
+			set[method] = true
+		}
+	}
+	t.root.collectMethods(clean, 1, len(clean), set)
+	if len(set) == 0 {
+		return nil
+	}
+	if set[http.MethodGet] {
+		set[http.MethodHead] = true
+	}
+	set[http.MethodOptions] = true
+	methods := make([]string, 0, len(set))
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
 }
 
 func (r *Router) getCtx(w http.ResponseWriter, req *http.Request) *Context {
 	c := r.pool.Get().(*Context)
 	c.sw = statusWriter{ResponseWriter: w}
 	c.Writer, c.Request = &c.sw, req
+	c.router = r
 	c.params = c.params[:0]
+	c.handlers, c.index, c.aborted = nil, 0, false
+	clear(c.errs)
+	c.errs, c.rendered = c.errs[:0], 0
 	c.Route = ""
 	if c.store != nil {
 		for k := range c.store {
@@ -151,16 +497,37 @@ func (r *Router) getCtx(w http.ResponseWriter, req *http.Request) *Context {
 func (r *Router) putCtx(c *Context) { r.pool.Put(c) }
 
 // Verify 基础健康检查
-func (r *Router) Verify() error { return verifyNode(r.root) }
+func (r *Router) Verify() error {
+	t := r.snapshot()
+	if err := verifyNode(t.root); err != nil {
+		return err
+	}
+	for _, ht := range t.hosts {
+		if err := verifyNode(ht.root); err != nil {
+			return fmt.Errorf("host %s: %w", ht.pattern, err)
+		}
+	}
+	return nil
+}
 
 func verifyNode(n *node) error {
 	if n.splat {
-		if n.pchild != nil || n.schild != nil {
+		if len(n.children) > 0 || len(n.mchildren) > 0 || len(n.pchildren) > 0 || n.schild != nil {
 			return fmt.Errorf("splat node must be terminal: %s", n.part)
 		}
 	}
-	if n.pchild != nil {
-		if err := verifyNode(n.pchild); err != nil {
+	for _, ch := range n.children {
+		if err := verifyNode(ch); err != nil {
+			return err
+		}
+	}
+	for _, ch := range n.mchildren {
+		if err := verifyNode(ch); err != nil {
+			return err
+		}
+	}
+	for _, ch := range n.pchildren {
+		if err := verifyNode(ch); err != nil {
 			return err
 		}
 	}
@@ -172,19 +539,57 @@ func verifyNode(n *node) error {
 	return nil
 }
 
-func (r *Router) Dump() string { return dumpNode(r.root, 0) }
-func dumpNode(n *node, depth int) string {
-	pad := strings.Repeat(" ", depth)
-	line := pad + "- '" + n.part + "'"
+// Dump renders the routing tree with the methods served at each node.
+// Static routes, which are kept in a separate map for speed, are merged in.
+// Host trees follow the default one, each under a "host" line.
+func (r *Router) Dump() string {
+	t := r.snapshot()
+	var b strings.Builder
+	dumpTree(&b, &t.routeTree)
+	for _, ht := range t.hosts {
+		b.WriteString("host " + ht.pattern + "\n")
+		dumpTree(&b, &ht.routeTree)
+	}
+	return b.String()
+}
+
+func dumpTree(b *strings.Builder, t *routeTree) {
+	root := t.root.clone()
+	for method, mm := range t.fast {
+		for p, h := range mm {
+			_ = root.add(method, splitPath(p), h, p)
+		}
+	}
+	dumpNode(b, root, 0)
+}
+
+func dumpNode(b *strings.Builder, n *node, depth int) {
+	b.WriteString(strings.Repeat(" ", depth))
+	b.WriteString("- '" + n.part + "'")
 	if len(n.handlers) > 0 {
-		line += " [H]"
+		methods := make([]string, 0, len(n.handlers))
+		for m := range n.handlers {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		b.WriteString(" [" + strings.Join(methods, ", ") + "]")
 	}
-	out := line + "\n"
-	if n.pchild != nil {
-		out += dumpNode(n.pchild, depth+1)
+	b.WriteByte('\n')
+	keys := make([]string, 0, len(n.children))
+	for k := range n.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dumpNode(b, n.children[k], depth+1)
+	}
+	for _, ch := range n.mchildren {
+		dumpNode(b, ch, depth+1)
+	}
+	for _, ch := range n.pchildren {
+		dumpNode(b, ch, depth+1)
 	}
 	if n.schild != nil {
-		out += dumpNode(n.schild, depth+1)
+		dumpNode(b, n.schild, depth+1)
 	}
-	return out
 }
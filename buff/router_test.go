@@ -1,9 +1,13 @@
 package buff
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRouterNormalizesDynamicPaths(t *testing.T) {
@@ -50,3 +54,648 @@ func TestRouterContextRouteReset(t *testing.T) {
 	}
 	r.putCtx(ctx2)
 }
+
+func TestRouterHeadFallsBackToGet(t *testing.T) {
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/static", func(c *Context) { _ = c.Text(http.StatusOK, "static") })
+	_ = r.Handle(http.MethodGet, "/users/:id", func(c *Context) { _ = c.Text(http.StatusOK, c.Route+" "+c.Param("id")) })
+	_ = r.Handle(http.MethodGet, "/own", func(c *Context) { _ = c.Text(http.StatusOK, "get") })
+	_ = r.Handle(http.MethodHead, "/own", func(c *Context) { c.Header("X-Head", "1"); c.Writer.WriteHeader(http.StatusNoContent) })
+	_ = r.Handle(http.MethodPost, "/post-only/:id", func(c *Context) {})
+
+	cases := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/static", http.StatusOK, "static"},
+		{"/users/7", http.StatusOK, "/users/:id 7"},
+		{"/own", http.StatusNoContent, ""},
+		{"/post-only/1", http.StatusMethodNotAllowed, ""},
+		{"/missing", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodHead, tc.path, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("HEAD %s: expected %d, got %d", tc.path, tc.status, rr.Code)
+		}
+		if tc.body != "" && rr.Body.String() != tc.body {
+			t.Fatalf("HEAD %s: expected handler output %q, got %q", tc.path, tc.body, rr.Body.String())
+		}
+	}
+}
+
+func TestEngineHeadSuppressesBody(t *testing.T) {
+	e := NewEngine()
+	e.GET("/ping", func(c *Context) { _ = c.Text(http.StatusOK, "pong") })
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Head(srv.URL + "/ping")
+	if err != nil {
+		t.Fatalf("head: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+	if resp.ContentLength != 4 {
+		t.Fatalf("expected Content-Length 4, got %d", resp.ContentLength)
+	}
+}
+
+func TestRouterAllowAndOptions(t *testing.T) {
+	r := NewRouter()
+	ok := func(c *Context) { _ = c.Text(http.StatusOK, "ok") }
+	_ = r.Handle(http.MethodGet, "/items", ok)
+	_ = r.Handle(http.MethodPost, "/items", ok)
+	_ = r.Handle(http.MethodPut, "/items/:id", ok)
+	_ = r.Handle(http.MethodDelete, "/items/:id", ok)
+	_ = r.Handle(http.MethodOptions, "/custom", func(c *Context) { _ = c.Text(http.StatusOK, "custom") })
+
+	cases := []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodDelete, "/items", http.StatusMethodNotAllowed, "GET, HEAD, OPTIONS, POST"},
+		{http.MethodGet, "/items/1", http.StatusMethodNotAllowed, "DELETE, OPTIONS, PUT"},
+		{http.MethodOptions, "/items", http.StatusNoContent, "GET, HEAD, OPTIONS, POST"},
+		{http.MethodOptions, "/items/1", http.StatusNoContent, "DELETE, OPTIONS, PUT"},
+		{http.MethodOptions, "/custom", http.StatusOK, ""},
+		{http.MethodOptions, "/missing", http.StatusNotFound, ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, rr.Code)
+		}
+		if got := rr.Header().Get("Allow"); got != tc.allow {
+			t.Fatalf("%s %s: expected Allow %q, got %q", tc.method, tc.path, tc.allow, got)
+		}
+	}
+}
+
+func TestRouterPathPolicy(t *testing.T) {
+	newRouter := func(p PathPolicy) *Router {
+		r := NewRouter()
+		r.SetPathPolicy(p)
+		ok := func(c *Context) { _ = c.Text(http.StatusOK, c.Route) }
+		_ = r.Handle(http.MethodGet, "/users", ok)
+		_ = r.Handle(http.MethodPost, "/users", ok)
+		_ = r.Handle(http.MethodGet, "/Users/:id/Posts", ok)
+		return r
+	}
+	cases := []struct {
+		policy       PathPolicy
+		method, path string
+		status       int
+		location     string
+	}{
+		{PathNormalize, http.MethodGet, "/users/", http.StatusOK, ""},
+		{PathNormalize, http.MethodGet, "//users", http.StatusOK, ""},
+		{PathStrict, http.MethodGet, "/users", http.StatusOK, ""},
+		{PathStrict, http.MethodGet, "/users/", http.StatusNotFound, ""},
+		{PathStrict, http.MethodGet, "/a/../users", http.StatusNotFound, ""},
+		{PathRedirect, http.MethodGet, "/users", http.StatusOK, ""},
+		{PathRedirect, http.MethodGet, "/users/?page=2", http.StatusMovedPermanently, "/users?page=2"},
+		{PathRedirect, http.MethodPost, "/users/", http.StatusPermanentRedirect, "/users"},
+		{PathRedirect, http.MethodGet, "/x/./../users", http.StatusMovedPermanently, "/users"},
+		{PathRedirect, http.MethodGet, "/USERS", http.StatusMovedPermanently, "/users"},
+		{PathRedirect, http.MethodGet, "/users/7/posts/", http.StatusMovedPermanently, "/Users/7/Posts"},
+		{PathRedirect, http.MethodGet, "/nope/", http.StatusNotFound, ""},
+	}
+	if err := newRouter(PathStrict).Handle(http.MethodGet, "/users/", func(*Context) {}); err == nil {
+		t.Fatal("expected the trailing-slash template to be the existing /users route")
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		newRouter(tc.policy).ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("policy %d %s %s: expected %d, got %d", tc.policy, tc.method, tc.path, tc.status, rr.Code)
+		}
+		if got := rr.Header().Get("Location"); got != tc.location {
+			t.Fatalf("policy %d %s %s: expected Location %q, got %q", tc.policy, tc.method, tc.path, tc.location, got)
+		}
+	}
+}
+
+func TestRouterRedirectEscapesTarget(t *testing.T) {
+	r := NewRouter()
+	r.SetPathPolicy(PathRedirect)
+	ok := func(c *Context) { _ = c.Text(http.StatusOK, c.Route) }
+	_ = r.Handle(http.MethodGet, "/:slug", ok)
+	_ = r.Handle(http.MethodGet, "/files/*path", ok)
+	_ = r.Handle(http.MethodGet, "/Docs/:name", ok)
+	for path, want := range map[string]string{
+		"/%5Cevil.com/":        "/%5Cevil.com",
+		"/%2F%2Fevil.com/":     "/%2F%2Fevil.com",
+		"//evil.com/":          "/evil.com",
+		"/files/a%3Fb%25c/":    "/files/a%3Fb%25c",
+		"/docs/a%3Fb%25c":      "/Docs/a%3Fb%25c",
+		"/docs/%5C%5Cevil.com": "/Docs/%5C%5Cevil.com",
+	} {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusMovedPermanently {
+			t.Fatalf("%s: expected 301, got %d", path, rr.Code)
+		}
+		if got := rr.Header().Get("Location"); got != want {
+			t.Fatalf("%s: expected Location %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestRouterParamConstraints(t *testing.T) {
+	r := NewRouter()
+	echo := func(c *Context) { _ = c.Text(http.StatusOK, c.Route) }
+	for _, p := range []string{
+		"/users/{id:int}",
+		"/users/{name:alpha}",
+		"/users/:any",
+		"/files/{name:[a-z]+\\.txt}",
+		"/files/{name}/raw",
+		"/objects/{id:uuid}",
+	} {
+		if err := r.Handle(http.MethodGet, p, echo); err != nil {
+			t.Fatalf("register %s: %v", p, err)
+		}
+	}
+	if err := r.Handle(http.MethodGet, "/bad/{id:[}", echo); err == nil {
+		t.Fatal("expected invalid regexp to be rejected")
+	}
+	// Renaming a param does not make a new route: it could never be reached.
+	for _, p := range []string{"/users/:name", "/users/{other}", "/users/{n:int}", "/files/{file}/raw"} {
+		if err := r.Handle(http.MethodGet, p, echo); err == nil {
+			t.Fatalf("expected %s to be rejected as a duplicate", p)
+		}
+	}
+	if err := r.Handle(http.MethodPost, "/users/:name", echo); err != nil {
+		t.Fatalf("register another method under a new param name: %v", err)
+	}
+	_ = r.Handle(http.MethodGet, "/docs/:name.json", echo)
+	if err := r.Handle(http.MethodGet, "/docs/:file.json", echo); err == nil {
+		t.Fatal("expected a renamed pattern segment to be rejected")
+	}
+	if err := r.Handle(http.MethodGet, "/docs/:file.yaml", echo); err != nil {
+		t.Fatalf("register a different pattern: %v", err)
+	}
+
+	cases := []struct {
+		path, route string
+	}{
+		{"/users/42", "/users/{id:int}"},
+		{"/users/-7", "/users/{id:int}"},
+		{"/users/bob", "/users/{name:alpha}"},
+		{"/users/bob-42", "/users/:any"},
+		{"/files/notes.txt", "/files/{name:[a-z]+\\.txt}"},
+		{"/files/notes.txt/raw", "/files/{name}/raw"},
+		{"/objects/123e4567-e89b-12d3-a456-426614174000", "/objects/{id:uuid}"},
+		{"/files/Notes.md", ""},
+		{"/objects/not-a-uuid", ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if tc.route == "" {
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("%s: expected 404, got %d", tc.path, rr.Code)
+			}
+			continue
+		}
+		if rr.Code != http.StatusOK || rr.Body.String() != tc.route {
+			t.Fatalf("%s: expected route %s, got %d %q", tc.path, tc.route, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestContextParamInt(t *testing.T) {
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/items/{id:int}/{name}", func(c *Context) {
+		id, err := c.ParamInt("id")
+		if err != nil || id != 12 {
+			t.Errorf("ParamInt: %d %v", id, err)
+		}
+		if _, err := c.ParamInt("name"); err == nil {
+			t.Error("expected error for non-numeric param")
+		}
+		if _, err := c.ParamInt64("missing"); err == nil {
+			t.Error("expected error for missing param")
+		}
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/12/widget", nil))
+}
+
+func TestRouterMatchPrecedence(t *testing.T) {
+	r := NewRouter()
+	echo := func(c *Context) {
+		_ = c.Text(http.StatusOK, c.Route+" "+c.Param("x")+c.Param("id")+c.Param("path"))
+	}
+	routes := [][2]string{
+		{http.MethodGet, "/a/b/c"},
+		{http.MethodGet, "/a/:x/d"},
+		{http.MethodGet, "/m/b/:y/z"},
+		{http.MethodGet, "/m/:x/:y"},
+		{http.MethodGet, "/files/:id"},
+		{http.MethodGet, "/files/*path"},
+		{http.MethodGet, "/files/static"},
+		{http.MethodGet, "/s/:x/deep"},
+		{http.MethodGet, "/s/*path"},
+		{http.MethodPost, "/p/b/:y"},
+		{http.MethodGet, "/p/:x/:y"},
+	}
+	for _, rt := range routes {
+		if err := r.Handle(rt[0], rt[1], echo); err != nil {
+			t.Fatalf("register %s %s: %v", rt[0], rt[1], err)
+		}
+	}
+
+	cases := []struct {
+		method, path string
+		status       int
+		body         string
+	}{
+		// static > param, with backtracking when the static branch dead-ends
+		{http.MethodGet, "/a/b/c", http.StatusOK, "/a/b/c "},
+		{http.MethodGet, "/a/b/d", http.StatusOK, "/a/:x/d b"},
+		{http.MethodGet, "/m/b/q/z", http.StatusOK, "/m/b/:y/z "},
+		{http.MethodGet, "/m/b/q", http.StatusOK, "/m/:x/:y b"},
+		// param > splat
+		{http.MethodGet, "/files/static", http.StatusOK, "/files/static "},
+		{http.MethodGet, "/files/42", http.StatusOK, "/files/:id 42"},
+		{http.MethodGet, "/files/a/b", http.StatusOK, "/files/*path a/b"},
+		// splat catches what the param branch cannot finish
+		{http.MethodGet, "/s/q/deep", http.StatusOK, "/s/:x/deep q"},
+		{http.MethodGet, "/s/q/shallow", http.StatusOK, "/s/*path q/shallow"},
+		// a route for the request method wins over a closer match without one
+		{http.MethodGet, "/p/b/c", http.StatusOK, "/p/:x/:y b"},
+		{http.MethodPost, "/p/b/c", http.StatusOK, "/p/b/:y "},
+		{http.MethodDelete, "/p/b/c", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != tc.status {
+			t.Fatalf("%s %s: expected %d, got %d", tc.method, tc.path, tc.status, rr.Code)
+		}
+		if tc.body != "" && rr.Body.String() != tc.body {
+			t.Fatalf("%s %s: expected %q, got %q", tc.method, tc.path, tc.body, rr.Body.String())
+		}
+		if tc.status == http.StatusMethodNotAllowed && rr.Header().Get("Allow") != "GET, HEAD, OPTIONS, POST" {
+			t.Fatalf("%s %s: unexpected Allow %q", tc.method, tc.path, rr.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestRouterEmbeddedParams(t *testing.T) {
+	r := NewRouter()
+	echo := func(c *Context) {
+		out := c.Route
+		for _, kv := range c.params {
+			out += " " + kv.key + "=" + kv.val
+		}
+		_ = c.Text(http.StatusOK, out)
+	}
+	for _, p := range []string{
+		"/files/:name.:ext",
+		"/files/:name",
+		"/v:version/users",
+		"/@:handle",
+		"/img/{w:int}x{h:int}.png",
+		"/user-:id",
+		"/docs/:name.json",
+	} {
+		if err := r.Handle(http.MethodGet, p, echo); err != nil {
+			t.Fatalf("register %s: %v", p, err)
+		}
+	}
+	if err := r.Handle(http.MethodGet, "/bad/:a:b", echo); err == nil {
+		t.Fatal("expected adjacent params to be rejected")
+	}
+
+	cases := []struct {
+		path, body string
+	}{
+		{"/files/report.pdf", "/files/:name.:ext name=report ext=pdf"},
+		{"/files/archive.tar.gz", "/files/:name.:ext name=archive ext=tar.gz"},
+		{"/files/README", "/files/:name name=README"},
+		{"/v2/users", "/v:version/users version=2"},
+		{"/@gopher", "/@:handle handle=gopher"},
+		{"/img/640x480.png", "/img/{w:int}x{h:int}.png w=640 h=480"},
+		{"/user-7", "/user-:id id=7"},
+		{"/docs/report.json", "/docs/:name.json name=report"},
+		{"/docs/report.pdf", ""},
+		{"/img/wide.png", ""},
+		{"/v/users", ""},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if tc.body == "" {
+			if rr.Code != http.StatusNotFound {
+				t.Fatalf("%s: expected 404, got %d %q", tc.path, rr.Code, rr.Body.String())
+			}
+			continue
+		}
+		if rr.Body.String() != tc.body {
+			t.Fatalf("%s: expected %q, got %d %q", tc.path, tc.body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestRouterURL(t *testing.T) {
+	r := NewRouter()
+	noop := func(c *Context) {}
+	mustName := func(name, path string) {
+		t.Helper()
+		if err := r.HandleNamed(name, http.MethodGet, path, noop); err != nil {
+			t.Fatalf("register %s: %v", path, err)
+		}
+	}
+	mustName("home", "/")
+	mustName("user", "/users/{id:int}")
+	mustName("file", "/files/:name.:ext")
+	mustName("static", "/static/*path")
+	if err := r.HandleNamed("user", http.MethodGet, "/other", noop); err == nil {
+		t.Fatal("expected duplicate route name to be rejected")
+	}
+	var fromCtx string
+	_ = r.HandleNamed("profile", http.MethodGet, "/profile/:who", func(c *Context) {
+		fromCtx, _ = c.URLFor("user", "id", "9")
+	})
+
+	cases := []struct {
+		name  string
+		pairs []string
+		want  string
+	}{
+		{"home", nil, "/"},
+		{"user", []string{"id", "42", "tab", "a b"}, "/users/42?tab=a+b"},
+		{"file", []string{"name", "my report", "ext", "pdf"}, "/files/my%20report.pdf"},
+		{"static", []string{"path", "css/site main.css"}, "/static/css/site%20main.css"},
+	}
+	for _, tc := range cases {
+		got, err := r.URL(tc.name, tc.pairs...)
+		if err != nil || got != tc.want {
+			t.Fatalf("URL(%s, %v) = %q, %v; want %q", tc.name, tc.pairs, got, err, tc.want)
+		}
+	}
+	for _, bad := range [][]string{
+		{"user"},
+		{"user", "id"},
+		{"user", "id", "abc"},
+		{"missing", "id", "1"},
+	} {
+		if got, err := r.URL(bad[0], bad[1:]...); err == nil {
+			t.Fatalf("URL%v: expected error, got %q", bad, got)
+		}
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/profile/me", nil))
+	if fromCtx != "/users/9" {
+		t.Fatalf("URLFor: got %q", fromCtx)
+	}
+}
+
+func TestRouterLiveUpdates(t *testing.T) {
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/v", func(c *Context) { _ = c.Text(http.StatusOK, "1") })
+	_ = r.Handle(http.MethodGet, "/items/:id", func(c *Context) { _ = c.Text(http.StatusOK, "old") })
+
+	get := func(path string) string {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Body.String()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_ = r.Handle(http.MethodGet, fmt.Sprintf("/gen/%d", i), func(c *Context) { _ = c.Text(http.StatusOK, "gen") })
+			_ = r.Handle(http.MethodGet, fmt.Sprintf("/gen/%d/:x", i), func(c *Context) {})
+			_ = r.Replace(http.MethodGet, "/v", func(c *Context) { _ = c.Text(http.StatusOK, "2") })
+		}
+	}()
+	for i := 0; i < 500; i++ {
+		if v := get("/v"); v != "1" && v != "2" {
+			t.Fatalf("unexpected body %q", v)
+		}
+		_ = get(fmt.Sprintf("/gen/%d", i%200))
+	}
+	<-done
+
+	if got := get("/v"); got != "2" {
+		t.Fatalf("expected replaced handler, got %q", got)
+	}
+	if got := get("/gen/199"); got != "gen" {
+		t.Fatalf("expected route added at runtime, got %q", got)
+	}
+	if err := r.Replace(http.MethodGet, "/items/:id", func(c *Context) { _ = c.Text(http.StatusOK, "new") }); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if got := get("/items/1"); got != "new" {
+		t.Fatalf("expected replaced param route, got %q", got)
+	}
+	if err := r.Handle(http.MethodGet, "/items/:id", func(c *Context) {}); err == nil {
+		t.Fatal("Handle must still reject duplicates")
+	}
+}
+
+func TestRouterRoutesRemoveDump(t *testing.T) {
+	r := NewRouter()
+	r.Use(Logger())
+	noop := func(c *Context) {}
+	_ = r.Handle(http.MethodGet, "/ping", noop)
+	_ = r.HandleNamed("user", http.MethodGet, "/users/:id", noop, Timeout(time.Second))
+	_ = r.Handle(http.MethodDelete, "/users/:id", noop)
+	_ = r.Handle(http.MethodGet, "/users/:id/posts", noop)
+
+	got := fmt.Sprint(r.Routes())
+	want := "[{GET /ping  1 } {DELETE /users/:id  1 } {GET /users/:id user 2 } {GET /users/:id/posts  1 }]"
+	if got != want {
+		t.Fatalf("Routes() = %s, want %s", got, want)
+	}
+
+	wantDump := "- '/'\n" +
+		" - 'ping' [GET]\n" +
+		" - 'users'\n" +
+		"  - ':id' [DELETE, GET]\n" +
+		"   - 'posts' [GET]\n"
+	if d := r.Dump(); d != wantDump {
+		t.Fatalf("Dump():\n%s\nwant:\n%s", d, wantDump)
+	}
+
+	if err := r.Remove(http.MethodGet, "/users/:id"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := r.Remove(http.MethodGet, "/users/:id"); err == nil {
+		t.Fatal("expected error removing a missing route")
+	}
+	if _, err := r.URL("user", "id", "1"); err == nil {
+		t.Fatal("expected route name to be dropped with the route")
+	}
+	_ = r.Remove(http.MethodGet, "/users/:id/posts")
+	_ = r.Remove(http.MethodGet, "/ping")
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "DELETE, OPTIONS" {
+		t.Fatalf("after remove: %d Allow=%q", rr.Code, rr.Header().Get("Allow"))
+	}
+	if d := r.Dump(); d != "- '/'\n - 'users'\n  - ':id' [DELETE]\n" {
+		t.Fatalf("Dump after remove:\n%s", d)
+	}
+	api := r.Host("API.test")
+	_ = api.GET("/a", func(c *Context) { _ = c.Text(http.StatusOK, "api") })
+	if err := r.Remove(http.MethodGet, "/a"); err == nil {
+		t.Fatal("expected the default host not to have the host route")
+	}
+	if err := api.Remove(http.MethodGet, "/a"); err != nil {
+		t.Fatalf("remove host route: %v", err)
+	}
+	if err := api.Remove(http.MethodGet, "/a"); err == nil {
+		t.Fatal("expected error removing a missing host route")
+	}
+	for _, ri := range r.Routes() {
+		if ri.Host != "" {
+			t.Fatalf("host route still listed: %+v", ri)
+		}
+	}
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Host = "api.test"
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected api.test to fall back to the default routes, got %d", rr.Code)
+	}
+}
+
+func TestNestedGroups(t *testing.T) {
+	e := NewEngine()
+	tag := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(c *Context) {
+				c.Writer.Header().Add("X-Chain", name)
+				next(c)
+			}
+		}
+	}
+	e.Use(tag("engine"))
+	api := e.Group("/api", tag("api"))
+	v1 := api.Group("v1/")
+	ok := func(c *Context) { _ = c.Text(http.StatusOK, c.Route) }
+	if err := v1.GET("/users/:id", ok, tag("route")); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := v1.Any("/echo", ok); err != nil {
+		t.Fatalf("register any: %v", err)
+	}
+	if err := api.GET("/", ok); err != nil {
+		t.Fatalf("register group root: %v", err)
+	}
+	// added after the routes on purpose
+	v1.Use(tag("v1"))
+
+	if err := v1.GET("/users/:id", ok); err == nil {
+		t.Fatal("expected duplicate group route to be rejected")
+	}
+	if err := e.GET("/api/v1/echo", ok); err == nil {
+		t.Fatal("expected Engine.GET to surface duplicate route errors")
+	}
+
+	cases := []struct {
+		method, path, route, chain string
+	}{
+		{http.MethodGet, "/api/v1/users/7", "/api/v1/users/:id", "engine,api,v1,route"},
+		{http.MethodPatch, "/api/v1/echo", "/api/v1/echo", "engine,api,v1"},
+		{http.MethodGet, "/api", "/api", "engine,api"},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		e.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != tc.route {
+			t.Fatalf("%s %s: %d %q", tc.method, tc.path, rr.Code, rr.Body.String())
+		}
+		if got := strings.Join(rr.Header().Values("X-Chain"), ","); got != tc.chain {
+			t.Fatalf("%s %s: chain %q, want %q", tc.method, tc.path, got, tc.chain)
+		}
+	}
+}
+
+func TestHostRouting(t *testing.T) {
+	r := NewRouter()
+	write := func(tag string) Handler {
+		return func(c *Context) { _ = c.Text(http.StatusOK, tag+" "+c.Param("tenant")+" "+c.Param("id")) }
+	}
+	_ = r.Handle(http.MethodGet, "/users/:id", write("default"))
+	if err := r.Host("API.example.com").GET("/users/:id", write("api")); err != nil {
+		t.Fatalf("register literal host: %v", err)
+	}
+	tenants := r.Host("{tenant:alpha}.example.com")
+	if err := tenants.Group("/v1").GET("/users/:id", write("tenant")); err != nil {
+		t.Fatalf("register host pattern: %v", err)
+	}
+	if err := r.Host("bad..example.com").GET("/", write("bad")); err == nil {
+		t.Fatal("expected invalid host pattern to be rejected")
+	}
+
+	cases := []struct {
+		host, path string
+		code       int
+		body       string
+	}{
+		{"api.example.com", "/users/1", http.StatusOK, "api  1"},
+		{"Api.Example.com:8080", "/users/2", http.StatusOK, "api  2"},
+		{"acme.example.com:8080", "/v1/users/3", http.StatusOK, "tenant acme 3"},
+		{"acme.example.com", "/users/3", http.StatusNotFound, ""},
+		{"42.example.com", "/users/4", http.StatusOK, "default  4"},
+		{"localhost", "/users/5", http.StatusOK, "default  5"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.Host = tc.host
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.code || (tc.body != "" && rr.Body.String() != tc.body) {
+			t.Fatalf("%s%s: %d %q", tc.host, tc.path, rr.Code, rr.Body.String())
+		}
+	}
+
+	routes := r.Routes()
+	if len(routes) != 3 || routes[1].Host != "api.example.com" || routes[2].Host != "{tenant:alpha}.example.com" {
+		t.Fatalf("Routes() = %v", routes)
+	}
+}
+
+func TestMountStripsPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Method+" "+r.URL.Path)
+	})
+	r := NewRouter()
+	if err := r.Group("/legacy").Mount("/v1", mux); err != nil {
+		t.Fatalf("mount: %v", err)
+	}
+	if err := r.Mount("/legacy/v1", mux); err == nil {
+		t.Fatal("expected mounting twice to fail")
+	}
+	_ = r.Handle(http.MethodGet, "/wrapped", Wrap(mux))
+
+	cases := []struct{ method, path, want string }{
+		{http.MethodGet, "/legacy/v1", "GET /"},
+		{http.MethodPost, "/legacy/v1/users/7", "POST /users/7"},
+		{http.MethodGet, "/legacy/v1/assets/", "GET /assets/"},
+		{http.MethodGet, "/wrapped", "GET /wrapped"},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.path, nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != tc.want {
+			t.Fatalf("%s %s: %d %q, want %q", tc.method, tc.path, rr.Code, rr.Body.String(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package buff
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errStreamingUnsupported = errors.New("response writer does not support streaming")
+
+// SSEvent is a single server-sent event. Data is written as-is when it is a
+// string or []byte and JSON-encoded otherwise; multi-line data is split into
+// several data fields at CRLF, CR and LF alike.
+type SSEvent struct {
+	ID    string
+	Event string
+	Data  any
+	Retry time.Duration
+}
+
+// SSE prepares the response for a server-sent event stream and sends the
+// headers right away. It fails with an error when the writer cannot stream,
+// as on the gnet engine without WithGNetWorkerPool, where the handler would
+// block the event loop and never see the client go away.
+func (c *Context) SSE() error {
+	if !canStream(c.Writer) {
+		return errStreamingUnsupported
+	}
+	h := c.Writer.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	c.Writer.WriteHeader(http.StatusOK)
+	return flushError(c.Writer)
+}
+
+// SSEvent sends one named event and flushes it to the client.
+func (c *Context) SSEvent(name string, data any) error {
+	return c.SSESend(SSEvent{Event: name, Data: data})
+}
+
+// SSESend writes ev and flushes it. It starts the stream if SSE has not been
+// called yet and returns the request context's error once the client is gone.
+func (c *Context) SSESend(ev SSEvent) error {
+	if err := c.Request.Context().Err(); err != nil {
+		return err
+	}
+	if c.Writer.Header().Get("Content-Type") != "text/event-stream" {
+		if err := c.SSE(); err != nil {
+			return err
+		}
+	}
+	var b strings.Builder
+	if ev.ID != "" {
+		writeSSEField(&b, "id", ev.ID)
+	}
+	if ev.Event != "" {
+		writeSSEField(&b, "event", ev.Event)
+	}
+	if ev.Retry > 0 {
+		writeSSEField(&b, "retry", strconv.FormatInt(ev.Retry.Milliseconds(), 10))
+	}
+	data, err := sseData(ev.Data)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(sseLineBreaks.Replace(data), "\n") {
+		b.WriteString("data: ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	return c.writeSSE(b.String())
+}
+
+// SSEStream sends every event received from events until the channel is
+// closed or the client disconnects, writing a comment line every keepAlive to
+// hold idle connections open. keepAlive <= 0 disables the comments.
+func (c *Context) SSEStream(keepAlive time.Duration, events <-chan SSEvent) error {
+	if err := c.SSE(); err != nil {
+		return err
+	}
+	var tick <-chan time.Time
+	if keepAlive > 0 {
+		t := time.NewTicker(keepAlive)
+		defer t.Stop()
+		tick = t.C
+	}
+	done := c.Request.Context().Done()
+	for {
+		select {
+		case <-done:
+			return c.Request.Context().Err()
+		case <-tick:
+			if err := c.writeSSE(": keepalive\n\n"); err != nil {
+				return err
+			}
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := c.SSESend(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Context) writeSSE(s string) error {
+	if _, err := c.Writer.Write([]byte(s)); err != nil {
+		return err
+	}
+	if err := flushError(c.Writer); err != nil {
+		return err
+	}
+	return c.Request.Context().Err()
+}
+
+// canStream reports whether w, or a writer it wraps, sends flushed data to
+// the client while the handler runs.
+func canStream(w http.ResponseWriter) bool {
+	for {
+		if s, ok := w.(interface{ canStream() bool }); ok {
+			return s.canStream()
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			_, ok = w.(http.Flusher)
+			return ok
+		}
+		w = u.Unwrap()
+	}
+}
+
+// flushError flushes w and returns the write error, if any. Writers that
+// cannot flush are left alone.
+func flushError(w http.ResponseWriter) error {
+	err := http.NewResponseController(w).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// sseLineBreaks turns every line terminator the event stream format knows,
+// CRLF, a lone CR and LF, into LF so data can be split into data fields.
+var sseLineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+func writeSSEField(b *strings.Builder, name, val string) {
+	val = strings.NewReplacer("\r", "", "\n", "").Replace(val)
+	b.WriteString(name)
+	b.WriteString(": ")
+	b.WriteString(val)
+	b.WriteByte('\n')
+}
+
+func sseData(v any) (string, error) {
+	switch d := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return d, nil
+	case []byte:
+		return string(d), nil
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,116 @@
+package buff
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContextSSEventFormat(t *testing.T) {
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/events", func(c *Context) {
+		_ = c.SSESend(SSEvent{ID: "7", Event: "update", Data: "a\nb", Retry: 2 * time.Second})
+		_ = c.SSEvent("json", map[string]int{"n": 1})
+		_ = c.SSEvent("cr", "x\rid: 9\revent: forged\r\ny")
+	})
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected event-stream content type, got %q", ct)
+	}
+	want := "id: 7\nevent: update\nretry: 2000\ndata: a\ndata: b\n\n" +
+		"event: json\ndata: {\"n\":1}\n\n" +
+		"event: cr\ndata: x\ndata: id: 9\ndata: event: forged\ndata: y\n\n"
+	if rr.Body.String() != want {
+		t.Fatalf("unexpected stream:\n%q\nwant:\n%q", rr.Body.String(), want)
+	}
+}
+
+func testSSEDisconnect(t *testing.T, base string, stopped <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, base+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	br := bufio.NewReader(resp.Body)
+	var sawKeepAlive, sawEvent bool
+	for !(sawKeepAlive && sawEvent) {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		sawKeepAlive = sawKeepAlive || strings.HasPrefix(line, ": keepalive")
+		sawEvent = sawEvent || line == "data: tick\n"
+	}
+	cancel()
+	_ = resp.Body.Close()
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected stream to stop with context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stream did not stop after client disconnect")
+	}
+}
+
+func newSSEEngine(stopped chan<- error) *Engine {
+	e := newBenchmarkEngine()
+	e.GET("/events", func(c *Context) {
+		events := make(chan SSEvent, 1)
+		events <- SSEvent{Event: "tick", Data: "tick"}
+		stopped <- c.SSEStream(20*time.Millisecond, events)
+	})
+	return e
+}
+
+func TestSSEStreamStopsOnDisconnect(t *testing.T) {
+	stopped := make(chan error, 1)
+	srv := httptest.NewServer(newSSEEngine(stopped))
+	defer srv.Close()
+	testSSEDisconnect(t, srv.URL, stopped)
+}
+
+func TestSSEStreamStopsOnDisconnectGNet(t *testing.T) {
+	stopped := make(chan error, 1)
+	addr := startGNetTestServer(t, newSSEEngine(stopped), WithGNetWorkerPool(4, 16))
+	testSSEDisconnect(t, "http://"+addr, stopped)
+}
+
+func TestSSEUnsupportedInlineGNet(t *testing.T) {
+	stopped := make(chan error, 1)
+	addr := startGNetTestServer(t, newSSEEngine(stopped))
+	resp, err := (&http.Client{Timeout: 2 * time.Second}).Get("http://" + addr + "/events")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	_ = resp.Body.Close()
+	if err := <-stopped; !errors.Is(err, errStreamingUnsupported) {
+		t.Fatalf("expected errStreamingUnsupported on the event loop, got %v", err)
+	}
+}
+
+type failingFlushWriter struct{ *httptest.ResponseRecorder }
+
+func (failingFlushWriter) FlushError() error { return errors.New("broken pipe") }
+
+func TestSSESendReportsFlushError(t *testing.T) {
+	var got error
+	r := NewRouter()
+	_ = r.Handle(http.MethodGet, "/events", func(c *Context) {
+		got = c.SSEvent("tick", "tick")
+	})
+	r.ServeHTTP(failingFlushWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if got == nil || got.Error() != "broken pipe" {
+		t.Fatalf("expected the flush error, got %v", got)
+	}
+}
@@ -0,0 +1,142 @@
+package buff
+
+// routeTable is an immutable snapshot of everything ServeHTTP reads. Once
+// published through Router.table it is never modified; writers edit a clone
+// and publish it as a whole.
+type routeTable struct {
+	routeTree // routes registered without a host, used when no host matches
+
+	hosts  []*hostTree           // literal hosts first, then patterns in registration order
+	names  map[string]string     // route name -> template
+	routes map[string]routeEntry // routeKey -> route
+
+	// fallback handlers wrapped in the global middleware stack
+	notFoundH, methodNotAllowedH, optionsH, redirectH Handler
+
+	errorH         ErrorHandler // nil means DefaultErrorHandler
+	pathPolicy     PathPolicy
+	problemDetails bool
+}
+
+// routeEntry keeps a route's handler, group and own middleware as
+// registered, so its chain can be rebuilt when global or group middleware
+// changes.
+type routeEntry struct {
+	RouteInfo
+	h     Handler
+	group *Group
+	mws   []Middleware
+}
+
+// routeTree holds the routes of one host: static paths in fast, the rest in
+// the trie under root.
+type routeTree struct {
+	root *node
+	fast map[string]map[string]Handler
+}
+
+func newRouteTree() routeTree {
+	return routeTree{root: newNode("/"), fast: make(map[string]map[string]Handler)}
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		routeTree: newRouteTree(),
+		names:     make(map[string]string),
+		routes:    make(map[string]routeEntry),
+	}
+}
+
+// setHandler replaces the compiled handler of an existing route.
+func (t *routeTree) setHandler(method, tpl string, h Handler) {
+	if mm := t.fast[method]; mm != nil {
+		if _, ok := mm[tpl]; ok {
+			mm[tpl] = h
+			return
+		}
+	}
+	if n := t.root.exact(splitPath(tpl)); n != nil {
+		n.handlers[method] = h
+	}
+}
+
+func (t *routeTable) clone() *routeTable {
+	c := *t
+	c.routeTree = t.routeTree.clone()
+	c.hosts = make([]*hostTree, len(t.hosts))
+	for i, ht := range t.hosts {
+		hc := *ht
+		hc.routeTree = ht.routeTree.clone()
+		c.hosts[i] = &hc
+	}
+	c.names = make(map[string]string, len(t.names))
+	for k, v := range t.names {
+		c.names[k] = v
+	}
+	c.routes = make(map[string]routeEntry, len(t.routes))
+	for k, v := range t.routes {
+		c.routes[k] = v
+	}
+	return &c
+}
+
+func (t routeTree) clone() routeTree {
+	c := routeTree{root: t.root.clone(), fast: make(map[string]map[string]Handler, len(t.fast))}
+	for method, mm := range t.fast {
+		cm := make(map[string]Handler, len(mm))
+		for p, h := range mm {
+			cm[p] = h
+		}
+		c.fast[method] = cm
+	}
+	return c
+}
+
+// tree returns the routes registered for host, "" being the default host,
+// or nil if there are none.
+func (t *routeTable) tree(host string) *routeTree {
+	if host == "" {
+		return &t.routeTree
+	}
+	for _, ht := range t.hosts {
+		if ht.pattern == host {
+			return &ht.routeTree
+		}
+	}
+	return nil
+}
+
+// clone deep-copies the subtree. Constraints and patterns are immutable and
+// shared.
+func (n *node) clone() *node {
+	c := *n
+	c.children = make(map[string]*node, len(n.children))
+	for k, ch := range n.children {
+		c.children[k] = ch.clone()
+	}
+	c.pchildren = cloneNodes(n.pchildren)
+	c.mchildren = cloneNodes(n.mchildren)
+	if n.schild != nil {
+		c.schild = n.schild.clone()
+	}
+	c.handlers = make(map[string]Handler, len(n.handlers))
+	for k, h := range n.handlers {
+		c.handlers[k] = h
+	}
+	c.tpls = make(map[string]string, len(n.tpls))
+	for k, v := range n.tpls {
+		c.tpls[k] = v
+	}
+	return &c
+}
+
+func cloneNodes(ns []*node) []*node {
+	if ns == nil {
+		return nil
+	}
+	out := make([]*node, len(ns))
+	for i, n := range ns {
+		out[i] = n.clone()
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package buff
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const certReloadInterval = time.Second
+
+// CertManager selects certificates by SNI server name and reloads them when
+// the files on disk change. Plug GetCertificate into a tls.Config or use
+// TLSConfig directly.
+type CertManager struct {
+	mu      sync.RWMutex
+	byName  map[string]*certEntry
+	entries []*certEntry
+}
+
+type certEntry struct {
+	certFile, keyFile string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertManager returns an empty manager; add certificates with Add.
+func NewCertManager() *CertManager {
+	return &CertManager{byName: map[string]*certEntry{}}
+}
+
+// Add loads a certificate pair and serves it for names. Without names the
+// DNS names of the certificate are used. The first certificate added is the
+// fallback for clients that send no or an unknown server name.
+func (m *CertManager) Add(certFile, keyFile string, names ...string) error {
+	e := &certEntry{certFile: certFile, keyFile: keyFile}
+	if err := e.load(); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		names = e.cert.Leaf.DNSNames
+		if len(names) == 0 && e.cert.Leaf.Subject.CommonName != "" {
+			names = []string{e.cert.Leaf.Subject.CommonName}
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, e)
+	for _, n := range names {
+		m.byName[strings.ToLower(n)] = e
+	}
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	e := m.lookup(strings.ToLower(strings.TrimSuffix(hello.ServerName, ".")))
+	if e == nil {
+		return nil, errors.New("buff: no certificate configured")
+	}
+	return e.current()
+}
+
+// TLSConfig returns a server config backed by GetCertificate.
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: m.GetCertificate,
+	}
+}
+
+func (m *CertManager) lookup(name string) *certEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if e, ok := m.byName[name]; ok {
+		return e
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if e, ok := m.byName["*"+name[i:]]; ok {
+			return e
+		}
+	}
+	if len(m.entries) > 0 {
+		return m.entries[0]
+	}
+	return nil
+}
+
+// current returns the loaded certificate, reloading it at most once per
+// certReloadInterval when either file has a newer modification time. A
+// failed reload keeps serving the previous certificate.
+func (e *certEntry) current() (*tls.Certificate, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Since(e.checkedAt) < certReloadInterval {
+		return e.cert, nil
+	}
+	e.checkedAt = time.Now()
+	if mt, err := latestModTime(e.certFile, e.keyFile); err == nil && mt.After(e.modTime) {
+		_ = e.loadLocked()
+	}
+	return e.cert, nil
+}
+
+func (e *certEntry) load() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.loadLocked()
+}
+
+func (e *certEntry) loadLocked() error {
+	mt, err := latestModTime(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(e.certFile, e.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parse certificate %s: %w", e.certFile, err)
+		}
+		cert.Leaf = leaf
+	}
+	e.cert, e.modTime, e.checkedAt = &cert, mt, time.Now()
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,317 @@
+package buff
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	gnet "github.com/panjf2000/gnet/v2"
+)
+
+func writeTestCert(t *testing.T, dir, name string, dnsNames ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestCertManagerSNIAndReload(t *testing.T) {
+	dir := t.TempDir()
+	aCert, aKey := writeTestCert(t, dir, "a", "a.test")
+	bCert, bKey := writeTestCert(t, dir, "b", "*.b.test")
+
+	m := NewCertManager()
+	if err := m.Add(aCert, aKey); err != nil {
+		t.Fatalf("add a: %v", err)
+	}
+	if err := m.Add(bCert, bKey); err != nil {
+		t.Fatalf("add b: %v", err)
+	}
+
+	for name, want := range map[string]string{"a.test": "a.test", "x.b.test": "*.b.test", "unknown": "a.test", "": "a.test"} {
+		cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("get certificate for %q: %v", name, err)
+		}
+		if cert.Leaf.DNSNames[0] != want {
+			t.Fatalf("server name %q: expected %s, got %s", name, want, cert.Leaf.DNSNames[0])
+		}
+	}
+
+	// replace a.test on disk and let the reload check run
+	writeTestCert(t, dir, "a", "a.test", "reloaded.test")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(aCert, future, future)
+	m.entries[0].checkedAt = time.Time{}
+	cert, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.test"})
+	if err != nil {
+		t.Fatalf("get certificate after reload: %v", err)
+	}
+	if len(cert.Leaf.DNSNames) != 2 {
+		t.Fatalf("expected reloaded certificate, got %v", cert.Leaf.DNSNames)
+	}
+}
+
+func waitForTLSServer(t *testing.T, client *http.Client, url string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(url)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("tls server %s not ready in time", url)
+}
+
+func tlsTestClient() *http.Client {
+	return &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, ServerName: "a.test"}},
+	}
+}
+
+// startGNetTLSTestServer runs e over TLS with a certificate for "a.test" and
+// returns its address once it answers /ping.
+func startGNetTLSTestServer(t *testing.T, e *Engine, opts ...GNetRunOption) string {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "a", "a.test")
+	m := NewCertManager()
+	if err := m.Add(certFile, keyFile); err != nil {
+		t.Fatalf("add cert: %v", err)
+	}
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	opts = append([]GNetRunOption{WithGNetTLS(m.TLSConfig()), WithGNetShutdownSignals(syscall.SIGUSR2)}, opts...)
+	errCh := make(chan error, 1)
+	go func() { errCh <- e.RunGNet(addr, opts...) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := gnet.Stop(ctx, ensureProtoAddr(addr)); err != nil {
+			t.Errorf("stop gnet server: %v", err)
+		}
+		if err := <-errCh; err != nil {
+			t.Errorf("gnet server error: %v", err)
+		}
+	})
+
+	client := tlsTestClient()
+	defer client.CloseIdleConnections()
+	waitForTLSServer(t, client, "https://"+addr+"/ping")
+	return addr
+}
+
+func TestGNetTLS(t *testing.T) {
+	e := newBenchmarkEngine()
+	e.POST("/echo", func(c *Context) {
+		b, _ := io.ReadAll(c.Request.Body)
+		_ = c.Text(http.StatusOK, fmt.Sprintf("%s %t", b, c.Request.TLS != nil))
+	})
+	addr := startGNetTLSTestServer(t, e)
+
+	client := tlsTestClient()
+	defer client.CloseIdleConnections()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Post("https://"+addr+"/echo", "text/plain", strings.NewReader("secret"))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != "secret true" {
+			t.Fatalf("unexpected body %q", string(body))
+		}
+	}
+}
+
+func TestGNetTLSWorkerPool(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	e := newBenchmarkEngine()
+	e.GET("/slow", func(c *Context) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+		time.Sleep(200 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		_ = c.Text(http.StatusOK, "done")
+	})
+	addr := startGNetTLSTestServer(t, e, WithGNetWorkerPool(1, 1))
+
+	client := tlsTestClient()
+	client.Timeout = 3 * time.Second
+	defer client.CloseIdleConnections()
+	var wg sync.WaitGroup
+	codes := make(chan int, 6)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get("https://" + addr + "/slow")
+			if err != nil {
+				codes <- 0
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	busy := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+		case http.StatusServiceUnavailable:
+			busy++
+		default:
+			t.Fatalf("unexpected status %d", code)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 1 {
+		t.Fatalf("expected one handler at a time, saw %d", peak)
+	}
+	if busy == 0 {
+		t.Fatalf("expected requests beyond the queue to get 503")
+	}
+}
+
+func TestGNetTLSStreamRequestBody(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	e := newBenchmarkEngine()
+	e.POST("/upload", func(c *Context) {
+		close(started)
+		<-release
+		n, err := io.Copy(io.Discard, c.Request.Body)
+		if err != nil {
+			_ = c.Text(http.StatusBadRequest, err.Error())
+			return
+		}
+		_ = c.Text(http.StatusOK, fmt.Sprintf("%d %s", n, c.Request.Trailer.Get("X-Sum")))
+	})
+	addr := startGNetTLSTestServer(t, e, WithGNetWorkerPool(4, 16), WithGNetStreamRequestBody(16))
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, ServerName: "a.test"})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+
+	head := "POST /upload HTTP/1.1\r\nHost: test\r\nTransfer-Encoding: chunked\r\n\r\n"
+	if _, err := io.WriteString(conn, head+"2020\r\n"+strings.Repeat("a", 32)); err != nil {
+		t.Fatalf("write head: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("handler was not dispatched before the body completed")
+	}
+	// The rest arrives in records far larger than the 16-byte body buffer,
+	// and in separate reads, while the handler is not reading; the ciphertext
+	// must wait for it rather than count as the peer running ahead.
+	rest := []string{
+		strings.Repeat("b", 8192) + "\r\n",
+		"0\r\nX-Sum: 8224\r\n\r\n",
+		"GET /ping HTTP/1.1\r\nHost: test\r\n\r\n",
+	}
+	for _, part := range rest {
+		if _, err := io.WriteString(conn, part); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	close(release)
+
+	br := bufio.NewReader(conn)
+	for _, want := range []string{"8224 8224", "pong"} {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if string(body) != want {
+			t.Fatalf("expected %q, got %q", want, string(body))
+		}
+	}
+}
+
+func TestEngineRunTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir, "a", "a.test")
+	e := newBenchmarkEngine()
+	addr := fmt.Sprintf("127.0.0.1:%d", freePort(t))
+	errCh := make(chan error, 1)
+	go func() { errCh <- e.RunTLS(addr, certFile, keyFile) }()
+
+	client := tlsTestClient()
+	defer client.CloseIdleConnections()
+	waitForTLSServer(t, client, "https://"+addr+"/ping")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := e.httpServer.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-errCh; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("server error: %v", err)
+	}
+}
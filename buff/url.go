@@ -0,0 +1,114 @@
+package buff
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// URL builds the path of the route registered under name. pairs alternate
+// param names and values; values are path-escaped and checked against the
+// param's constraint, and pairs that name no param become the query string.
+//
+//	r.URL("user", "id", "42", "tab", "posts") // "/users/42?tab=posts"
+func (r *Router) URL(name string, pairs ...string) (string, error) {
+	tpl, ok := r.snapshot().names[name]
+	if !ok {
+		return "", fmt.Errorf("route %q not found", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", errors.New("url params must be key/value pairs")
+	}
+	values := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		values[pairs[i]] = pairs[i+1]
+	}
+	used := make(map[string]bool, len(values))
+
+	var b strings.Builder
+	for _, seg := range splitPath(tpl) {
+		b.WriteByte('/')
+		if err := fillSegment(&b, seg, values, used); err != nil {
+			return "", fmt.Errorf("route %q: %w", name, err)
+		}
+	}
+	if b.Len() == 0 {
+		b.WriteByte('/')
+	}
+
+	query := url.Values{}
+	for i := 0; i < len(pairs); i += 2 {
+		if !used[pairs[i]] {
+			query.Add(pairs[i], pairs[i+1])
+		}
+	}
+	if len(query) > 0 {
+		b.WriteByte('?')
+		b.WriteString(query.Encode())
+	}
+	return b.String(), nil
+}
+
+// URLFor builds a URL for a named route of the router serving c.
+func (c *Context) URLFor(name string, pairs ...string) (string, error) {
+	if c.router == nil {
+		return "", errors.New("context is not bound to a router")
+	}
+	return c.router.URL(name, pairs...)
+}
+
+func fillSegment(b *strings.Builder, seg string, values map[string]string, used map[string]bool) error {
+	lookup := func(name string, cons *paramConstraint) (string, error) {
+		v, ok := values[name]
+		if !ok || v == "" {
+			return "", fmt.Errorf("missing param %q", name)
+		}
+		if !cons.ok(v) {
+			return "", fmt.Errorf("param %q: %q does not match %s", name, v, cons)
+		}
+		used[name] = true
+		return v, nil
+	}
+	switch {
+	case strings.HasPrefix(seg, "*"):
+		v, err := lookup(seg[1:], nil)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(strings.TrimPrefix(v, "/"), "/")
+		for i, p := range parts {
+			parts[i] = url.PathEscape(p)
+		}
+		b.WriteString(strings.Join(parts, "/"))
+	case isParamSegment(seg):
+		name, cons, err := parseParamSegment(seg)
+		if err != nil {
+			return err
+		}
+		v, err := lookup(name, cons)
+		if err != nil {
+			return err
+		}
+		b.WriteString(url.PathEscape(v))
+	case isPatternSegment(seg):
+		pat, err := parseSegPattern(seg)
+		if err != nil {
+			return err
+		}
+		for _, tok := range pat.tokens {
+			if !tok.isParam() {
+				b.WriteString(tok.lit)
+				continue
+			}
+			v, err := lookup(tok.name, tok.cons)
+			if err != nil {
+				return err
+			}
+			b.WriteString(url.PathEscape(v))
+		}
+	default:
+		b.WriteString(seg)
+	}
+	return nil
+}
@@ -28,6 +28,19 @@ func (sw *statusWriter) Write(p []byte) (int, error) {
 	sw.bytes += n
 	return n, err
 }
+func (sw *statusWriter) Flush() { _ = sw.FlushError() }
+
+// FlushError flushes like Flush and reports a failed write.
+func (sw *statusWriter) FlushError() error {
+	if !sw.wrote {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return flushError(sw.ResponseWriter)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
+
 func (sw *statusWriter) Status() int {
 	if sw.wrote {
 		return sw.status
@@ -0,0 +1,371 @@
+package buff
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+)
+
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const defaultWSReadLimit = 32 << 20
+
+// WSMessageType is a WebSocket frame opcode.
+type WSMessageType int
+
+const (
+	WSTextMessage   WSMessageType = 1
+	WSBinaryMessage WSMessageType = 2
+	WSCloseMessage  WSMessageType = 8
+	WSPingMessage   WSMessageType = 9
+	WSPongMessage   WSMessageType = 10
+
+	wsContinuation WSMessageType = 0
+)
+
+// Close status codes defined by RFC 6455 section 7.4.1.
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseAbnormal        = 1006
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseTooLarge        = 1009
+	WSCloseInternalError   = 1011
+)
+
+// ErrWSClosed is returned when writing after the close frame was sent.
+var ErrWSClosed = errors.New("websocket: close sent")
+
+// WSCloseError carries the status of a closed WebSocket connection, either
+// received from the peer or sent by us after a protocol violation.
+type WSCloseError struct {
+	Code int
+	Text string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// WSHandler serves an upgraded WebSocket connection. The connection is closed
+// when the handler returns.
+type WSHandler func(c *Context, ws *WSConn)
+
+// WSConn is a server-side WebSocket connection.
+type WSConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+	bw   *bufio.Writer
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	readLimit   int64
+	pongHandler func([]byte)
+}
+
+// Upgrade performs the RFC 6455 opening handshake and takes over the
+// connection. On failure an error response has already been written.
+func (c *Context) Upgrade() (*WSConn, error) {
+	r := c.Request
+	if r.Method != http.MethodGet {
+		_ = c.fail(http.StatusMethodNotAllowed, "websocket upgrade requires GET")
+		return nil, errors.New("websocket: method not GET")
+	}
+	if !isUpgradeRequest(r.Header) {
+		_ = c.fail(http.StatusBadRequest, "not a websocket upgrade")
+		return nil, errors.New("websocket: missing upgrade headers")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		c.Header("Sec-WebSocket-Version", "13")
+		_ = c.fail(http.StatusUpgradeRequired, "unsupported websocket version")
+		return nil, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if k, err := base64.StdEncoding.DecodeString(key); err != nil || len(k) != 16 {
+		_ = c.fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+		return nil, errors.New("websocket: invalid key")
+	}
+
+	conn, rw, err := http.NewResponseController(c.Writer).Hijack()
+	if err != nil {
+		_ = c.fail(http.StatusInternalServerError, "websocket not supported")
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c.sw.status, c.sw.wrote = http.StatusSwitchingProtocols, true
+	return &WSConn{conn: conn, br: rw.Reader, bw: rw.Writer, readLimit: defaultWSReadLimit}, nil
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// WS registers a WebSocket endpoint at path. Both engines support it; the
+// gnet engine serves upgrade requests on a goroutine of their own, off the
+// event loop and outside WithGNetWorkerPool, so open sockets never hold up
+// other requests. Registration errors are returned as
+// by Handle.
+func (e *Engine) WS(path string, h WSHandler) error {
+	return e.R.Handle(http.MethodGet, path, func(c *Context) {
+		ws, err := c.Upgrade()
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		h(c, ws)
+	})
+}
+
+// SetReadLimit caps the size of a reassembled message; larger messages close
+// the connection with 1009.
+func (ws *WSConn) SetReadLimit(n int64) { ws.readLimit = n }
+
+// SetPongHandler installs a callback for pong frames. Pings are answered
+// automatically.
+func (ws *WSConn) SetPongHandler(h func(data []byte)) { ws.pongHandler = h }
+
+// RemoteAddr returns the peer address.
+func (ws *WSConn) RemoteAddr() net.Addr { return ws.conn.RemoteAddr() }
+
+// ReadMessage returns the next data message, reassembling fragments and
+// handling control frames in between. A close frame from the peer is echoed
+// and reported as *WSCloseError.
+func (ws *WSConn) ReadMessage() (WSMessageType, []byte, error) {
+	var (
+		msgType WSMessageType = -1
+		msg     []byte
+	)
+	for {
+		fin, op, payload, err := ws.readFrame(int64(len(msg)))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case WSPingMessage:
+			if err := ws.writeFrame(WSPongMessage, payload); err != nil && !errors.Is(err, ErrWSClosed) {
+				return 0, nil, err
+			}
+			continue
+		case WSPongMessage:
+			if ws.pongHandler != nil {
+				ws.pongHandler(payload)
+			}
+			continue
+		case WSCloseMessage:
+			return 0, nil, ws.handleClose(payload)
+		case WSTextMessage, WSBinaryMessage:
+			if msgType != -1 {
+				return 0, nil, ws.fail(WSCloseProtocolError, "expected continuation frame")
+			}
+			msgType, msg = op, payload
+		case wsContinuation:
+			if msgType == -1 {
+				return 0, nil, ws.fail(WSCloseProtocolError, "unexpected continuation frame")
+			}
+			msg = append(msg, payload...)
+		default:
+			return 0, nil, ws.fail(WSCloseProtocolError, "unknown opcode")
+		}
+		if fin {
+			if msgType == WSTextMessage && !utf8.Valid(msg) {
+				return 0, nil, ws.fail(WSCloseInvalidPayload, "invalid utf-8")
+			}
+			return msgType, msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame; buffered is the size of the message assembled
+// so far and is used to enforce the read limit before allocating.
+func (ws *WSConn) readFrame(buffered int64) (bool, WSMessageType, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.br, hdr[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := hdr[0]&0x80 != 0
+	if hdr[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(WSCloseProtocolError, "reserved bits set")
+	}
+	op := WSMessageType(hdr[0] & 0x0f)
+	if hdr[1]&0x80 == 0 {
+		return false, 0, nil, ws.fail(WSCloseProtocolError, "client frame not masked")
+	}
+	length := int64(hdr[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		v := binary.BigEndian.Uint64(ext[:])
+		if v>>63 != 0 {
+			return false, 0, nil, ws.fail(WSCloseProtocolError, "invalid payload length")
+		}
+		length = int64(v)
+	}
+	if op >= WSCloseMessage {
+		if !fin || length > 125 {
+			return false, 0, nil, ws.fail(WSCloseProtocolError, "invalid control frame")
+		}
+	} else if ws.readLimit > 0 && buffered+length > ws.readLimit {
+		return false, 0, nil, ws.fail(WSCloseTooLarge, "message too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return fin, op, payload, nil
+}
+
+func (ws *WSConn) handleClose(payload []byte) error {
+	code, text := WSCloseNoStatus, ""
+	switch {
+	case len(payload) == 1:
+		return ws.fail(WSCloseProtocolError, "invalid close payload")
+	case len(payload) >= 2:
+		code = int(binary.BigEndian.Uint16(payload))
+		text = string(payload[2:])
+		if !validCloseCode(code) {
+			return ws.fail(WSCloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(text) {
+			return ws.fail(WSCloseInvalidPayload, "invalid close reason")
+		}
+	}
+	echo := code
+	if echo == WSCloseNoStatus {
+		echo = WSCloseNormal
+	}
+	_ = ws.WriteClose(echo, "")
+	return &WSCloseError{Code: code, Text: text}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != WSCloseNoStatus && code != WSCloseAbnormal
+	}
+	return false
+}
+
+// fail sends a close frame for a protocol violation and returns the matching error.
+func (ws *WSConn) fail(code int, text string) error {
+	_ = ws.WriteClose(code, text)
+	return &WSCloseError{Code: code, Text: text}
+}
+
+// WriteMessage sends data as a single frame. Ping and pong payloads are
+// limited to 125 bytes.
+func (ws *WSConn) WriteMessage(t WSMessageType, data []byte) error {
+	switch t {
+	case WSTextMessage, WSBinaryMessage:
+	case WSPingMessage, WSPongMessage:
+		if len(data) > 125 {
+			return errors.New("websocket: control frame too large")
+		}
+	case WSCloseMessage:
+		return errors.New("websocket: use WriteClose to send a close frame")
+	default:
+		return fmt.Errorf("websocket: invalid message type %d", t)
+	}
+	return ws.writeFrame(t, data)
+}
+
+// WriteClose starts the closing handshake. Further writes return ErrWSClosed.
+func (ws *WSConn) WriteClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return ws.writeFrame(WSCloseMessage, payload)
+}
+
+// Close sends a normal close frame if none was sent yet and closes the
+// underlying connection.
+func (ws *WSConn) Close() error {
+	_ = ws.WriteClose(WSCloseNormal, "")
+	return ws.conn.Close()
+}
+
+func (ws *WSConn) writeFrame(op WSMessageType, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWSClosed
+	}
+	if op == WSCloseMessage {
+		ws.closeSent = true
+	}
+	var hdr [10]byte
+	hdr[0] = 0x80 | byte(op)
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		hdr[1] = byte(l)
+	case l <= 0xffff:
+		hdr[1] = 126
+		binary.BigEndian.PutUint16(hdr[2:], uint16(l))
+		n = 4
+	default:
+		hdr[1] = 127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(l))
+		n = 10
+	}
+	ws.bw.Write(hdr[:n])
+	ws.bw.Write(payload)
+	return ws.bw.Flush()
+}
+
+// IsWSCloseError reports whether err is a close with one of the given codes.
+func IsWSCloseError(err error, codes ...int) bool {
+	var ce *WSCloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	for _, c := range codes {
+		if ce.Code == c {
+			return true
+		}
+	}
+	return len(codes) == 0
+}
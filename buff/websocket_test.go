@@ -0,0 +1,228 @@
+package buff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type wsTestClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWSTest(t *testing.T, addr, path string) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, key)
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	return &wsTestClient{conn: conn, br: br}
+}
+
+func (c *wsTestClient) writeFrame(t *testing.T, fin bool, op WSMessageType, payload []byte) {
+	t.Helper()
+	b0 := byte(op)
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0, 0x80 | byte(len(payload))}
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+	for i, p := range payload {
+		frame = append(frame, p^mask[i&3])
+	}
+	if _, err := c.conn.Write(frame); err != nil {
+		t.Fatalf("write frame: %v", err)
+	}
+}
+
+func (c *wsTestClient) readFrame(t *testing.T) (WSMessageType, []byte) {
+	t.Helper()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	if hdr[1]&0x80 != 0 {
+		t.Fatalf("server frames must not be masked")
+	}
+	n := int(hdr[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		_, _ = io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatalf("read payload: %v", err)
+	}
+	return WSMessageType(hdr[0] & 0x0f), payload
+}
+
+func newWSEchoEngine() *Engine {
+	e := newBenchmarkEngine()
+	e.WS("/ws", func(c *Context, ws *WSConn) {
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	})
+	return e
+}
+
+func testWSEcho(t *testing.T, addr string) {
+	c := dialWSTest(t, addr, "/ws")
+	defer c.conn.Close()
+
+	c.writeFrame(t, true, WSTextMessage, []byte("hello"))
+	if op, msg := c.readFrame(t); op != WSTextMessage || string(msg) != "hello" {
+		t.Fatalf("unexpected echo %d %q", op, msg)
+	}
+
+	// fragmented message with a ping in between
+	c.writeFrame(t, false, WSBinaryMessage, []byte("frag"))
+	c.writeFrame(t, true, WSPingMessage, []byte("p"))
+	c.writeFrame(t, true, wsContinuation, []byte("ment"))
+	if op, msg := c.readFrame(t); op != WSPongMessage || string(msg) != "p" {
+		t.Fatalf("expected pong, got %d %q", op, msg)
+	}
+	if op, msg := c.readFrame(t); op != WSBinaryMessage || string(msg) != "fragment" {
+		t.Fatalf("unexpected reassembled message %d %q", op, msg)
+	}
+
+	c.writeFrame(t, true, WSCloseMessage, []byte{0x03, 0xe8})
+	op, msg := c.readFrame(t)
+	if op != WSCloseMessage || binary.BigEndian.Uint16(msg) != WSCloseNormal {
+		t.Fatalf("expected close echo, got %d %v", op, msg)
+	}
+	if _, err := c.br.ReadByte(); err != io.EOF {
+		t.Fatalf("expected server to close the connection, got %v", err)
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	srv := httptest.NewServer(newWSEchoEngine())
+	defer srv.Close()
+	testWSEcho(t, strings.TrimPrefix(srv.URL, "http://"))
+}
+
+func TestWebSocketEchoGNet(t *testing.T) {
+	for name, opts := range map[string][]GNetRunOption{
+		"inline":  nil,
+		"workers": {WithGNetWorkerPool(4, 16)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			addr := startGNetTestServer(t, newWSEchoEngine(), opts...)
+			testWSEcho(t, addr)
+		})
+	}
+}
+
+func TestGNetWebSocketDoesNotHoldWorker(t *testing.T) {
+	const sockets = 2
+	addr := startGNetTestServer(t, newWSEchoEngine(), WithGNetWorkerPool(sockets, 16))
+	for i := 0; i < sockets; i++ {
+		c := dialWSTest(t, addr, "/ws")
+		defer c.conn.Close()
+		c.writeFrame(t, true, WSTextMessage, []byte("hi"))
+		if op, msg := c.readFrame(t); op != WSTextMessage || string(msg) != "hi" {
+			t.Fatalf("socket %d: unexpected echo %d %q", i, op, msg)
+		}
+	}
+	resp, err := (&http.Client{Timeout: 2 * time.Second}).Get("http://" + addr + "/ping")
+	if err != nil {
+		t.Fatalf("get with every worker's worth of sockets open: %v", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestGNetInlineRefusedUpgradeCloses(t *testing.T) {
+	addr := startGNetTestServer(t, newWSEchoEngine())
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	raw := "GET /ws HTTP/1.1\r\nHost: t\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"
+	if _, err := io.WriteString(conn, raw); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusUpgradeRequired {
+		t.Fatalf("expected 426, got %d", resp.StatusCode)
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("expected the connection to close after a refused upgrade, got %v", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	srv := httptest.NewServer(newWSEchoEngine())
+	defer srv.Close()
+	c := dialWSTest(t, strings.TrimPrefix(srv.URL, "http://"), "/ws")
+	defer c.conn.Close()
+
+	// unmasked client frame
+	if _, err := c.conn.Write([]byte{0x81, 0x01, 'x'}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	op, msg := c.readFrame(t)
+	if op != WSCloseMessage || binary.BigEndian.Uint16(msg) != WSCloseProtocolError {
+		t.Fatalf("expected close 1002, got %d %v", op, msg)
+	}
+}
+
+func TestWebSocketRegistrationError(t *testing.T) {
+	e := newWSEchoEngine()
+	if err := e.WS("/ws", func(*Context, *WSConn) {}); err == nil {
+		t.Fatalf("expected an error for a duplicate route")
+	}
+	if err := e.WS("/bad/:", func(*Context, *WSConn) {}); err == nil {
+		t.Fatalf("expected an error for an invalid pattern")
+	}
+}
+
+func TestWebSocketUpgradeRejectsPlainRequest(t *testing.T) {
+	e := newWSEchoEngine()
+	rr := httptest.NewRecorder()
+	e.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
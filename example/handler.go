@@ -1,13 +1,20 @@
 package main
 
-import "github.com/J1407B-K/buff/buff"
+import (
+	"errors"
+	"io"
+
+	"github.com/J1407B-K/buff/buff"
+)
 
 type Foo struct {
 	Bar string `json:"bar"`
 }
 
-func PongHandler(c *buff.Context) {
+func PongHandler(c *buff.Context) error {
 	var foo Foo
-	c.Bind(&foo)
-	c.JSON(200, foo)
+	if err := c.Bind(&foo); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return c.JSON(200, foo)
 }
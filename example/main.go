@@ -38,7 +38,7 @@ func main() {
 	e := buff.NewEngine()
 	e.Use(buff.Logger(), buff.Timeout(5*time.Second))
 
-	e.GET("/ping", PongHandler)
+	e.GET("/ping", buff.WrapErr(PongHandler))
 	e.GET("/hello/:name", func(c *buff.Context) {
 		c.JSON(200, map[string]string{"hi": c.Param("name")})
 	})